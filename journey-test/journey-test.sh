@@ -7,5 +7,11 @@ testdirs=$(find . -name 'test*' -type d )
 for t in $testdirs; do
     echo "running test in $t"
     kustomize build --enable-alpha-plugins "$t" > "$t"/output.yaml
-    diff "$t"/expected.yaml "$t"/output.yaml
+    if [ -f "$t"/age-key.txt ]; then
+        # SOPS encrypted output is compared decrypted, SOPS may indent differently than Kustomize
+        SOPS_AGE_KEY_FILE="$t"/age-key.txt sops -d "$t"/output.yaml > "$t"/decrypted.yaml
+        diff -w "$t"/expected.yaml "$t"/decrypted.yaml
+    else
+        diff "$t"/expected.yaml "$t"/output.yaml
+    fi
 done
@@ -0,0 +1,73 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// keys of a kubernetes.io/basic-auth secret
+const (
+	basicAuthUsernameKey = "username"
+	basicAuthPasswordKey = "password"
+)
+
+// BasicAuthOptions maps the resolved credentials to the keys of a kubernetes.io/basic-auth secret
+type BasicAuthOptions struct {
+	UsernameKey string `json:"usernameKey,omitempty" yaml:"usernameKey,omitempty"`
+	PasswordKey string `json:"passwordKey,omitempty" yaml:"passwordKey,omitempty"`
+}
+
+func (o BasicAuthOptions) keys() []string {
+	var keys []string
+	for _, key := range []string{o.UsernameKey, o.PasswordKey} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// buildBasicAuth returns the username and password keys of the secret
+func buildBasicAuth(options BasicAuthOptions, data kvMap) (map[string][]byte, error) {
+	built := make(map[string][]byte)
+	if options.UsernameKey != "" {
+		username, err := decodedValue(data, options.UsernameKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "no username")
+		}
+		built[basicAuthUsernameKey] = username
+	}
+	if options.PasswordKey != "" {
+		password, err := decodedValue(data, options.PasswordKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "no password")
+		}
+		built[basicAuthPasswordKey] = password
+	}
+	return built, nil
+}
+
+// validateBasicAuth checks that the secret has a username or password like Kubernetes requires
+func validateBasicAuth(data kvMap) error {
+	_, hasUsername := data[basicAuthUsernameKey]
+	_, hasPassword := data[basicAuthPasswordKey]
+	if !hasUsername && !hasPassword {
+		return errors.Errorf("a secret of type %s needs the key %s or %s", typeBasicAuth, basicAuthUsernameKey, basicAuthPasswordKey)
+	}
+	return nil
+}
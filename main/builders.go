@@ -0,0 +1,257 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+
+	"github.com/pkg/errors"
+)
+
+// Kubernetes secret types with builders assembling their data
+const (
+	typeDockerConfigJSON = "kubernetes.io/dockerconfigjson"
+	typeTLS              = "kubernetes.io/tls"
+	typeBasicAuth        = "kubernetes.io/basic-auth"
+)
+
+// builderKeys returns the keys the builder of the secret type reads in addition to the keys of the secret
+func (p *KGCPSecret) builderKeys() []string {
+	var keys []string
+	for _, registry := range p.Registries {
+		keys = append(keys, registry.UsernameKey, registry.PasswordKey)
+	}
+	keys = append(keys, p.TLS.keys()...)
+	keys = append(keys, p.BasicAuth.keys()...)
+	keys = append(keys, p.Htpasswd.keys()...)
+	for _, keystore := range p.Keystores {
+		keys = append(keys, keystore.keys()...)
+	}
+	return keys
+}
+
+// withBuilderKeys returns the KGCPSecret with the keys read by the builder of the secret type added to its keys
+func (p *KGCPSecret) withBuilderKeys() *KGCPSecret {
+	extended := *p
+	extended.Keys = append([]string{}, p.Keys...)
+	for _, key := range p.builderKeys() {
+		if !containsKey(extended.Keys, key) {
+			extended.Keys = append(extended.Keys, key)
+		}
+	}
+	return &extended
+}
+
+func (p *KGCPSecret) validateBuilders() error {
+	if len(p.Registries) > 0 {
+		if !p.allowsType(typeDockerConfigJSON) {
+			return errors.Errorf("registries can only be used with type %s", typeDockerConfigJSON)
+		}
+		if err := p.validateBuilderOutput("registries"); err != nil {
+			return err
+		}
+	}
+	for i, registry := range p.Registries {
+		if err := registry.validate(); err != nil {
+			return errors.Wrapf(err, "invalid registries entry %d", i+1)
+		}
+	}
+	if p.TLS != (TLSOptions{}) {
+		if !p.allowsType(typeTLS) {
+			return errors.Errorf("tls can only be used with type %s", typeTLS)
+		}
+		if err := p.validateBuilderOutput("tls"); err != nil {
+			return err
+		}
+	}
+	if err := p.TLS.validate(); err != nil {
+		return err
+	}
+	if p.BasicAuth != (BasicAuthOptions{}) {
+		if !p.allowsType(typeBasicAuth) {
+			return errors.Errorf("basicAuth can only be used with type %s", typeBasicAuth)
+		}
+		if err := p.validateBuilderOutput("basicAuth"); err != nil {
+			return err
+		}
+	}
+	if len(p.Keystores) > 0 {
+		if err := p.validateBuilderOutput("keystores"); err != nil {
+			return err
+		}
+	}
+	built := []string{}
+	if p.Htpasswd.configured() {
+		if err := p.validateBuilderOutput("htpasswd"); err != nil {
+			return err
+		}
+		if err := p.Htpasswd.validate(); err != nil {
+			return err
+		}
+		built = append(built, p.Htpasswd.key())
+	}
+	for i, keystore := range p.Keystores {
+		if err := keystore.validate(); err != nil {
+			return errors.Wrapf(err, "invalid keystores entry %d", i+1)
+		}
+		built = append(built, keystore.Key)
+	}
+	for i, key := range built {
+		if containsKey(p.Keys, key) || containsKey(p.builderKeys(), key) || containsKey(built[:i], key) {
+			return errors.Errorf("key '%s' is already used by another key", key)
+		}
+	}
+	return nil
+}
+
+// validateBuilderOutput checks that the output is a secret, which builders can assemble data for
+func (p *KGCPSecret) validateBuilderOutput(setting string) error {
+	switch p.output() {
+	case outputSecret, outputSealedSecret, outputSOPS:
+		return nil
+	}
+	return errors.Errorf("%s cannot be used with output '%s'", setting, p.output())
+}
+
+// getSecretData resolves the keys of the secret and assembles the data of its type.
+// It returns the data and the type of the secret.
+func getSecretData(ctx context.Context, client *secretmanager.Client,
+	plugin *KGCPSecret, listGCPSecrets secretsGetter, getGCPSecretValue secretValueGetter) (kvMap, string, error) {
+	resolving := plugin.withBuilderKeys()
+	data, err := createGCPSecretValuesGetter(resolving, listGCPSecrets)(ctx, client, resolving, getGCPSecretValue)
+	if err != nil {
+		return nil, "", err
+	}
+	if data, err = buildData(plugin, data); err != nil {
+		return nil, "", err
+	}
+	secretType := plugin.secretType(data)
+	return data, secretType, validateTypedData(plugin, secretType, data)
+}
+
+// buildData replaces the values read by the builders with the data they assemble
+func buildData(plugin *KGCPSecret, data kvMap) (kvMap, error) {
+	built := make(map[string][]byte)
+	if len(plugin.Registries) > 0 {
+		config, err := buildDockerConfigJSON(plugin.Registries, data)
+		if err != nil {
+			return nil, err
+		}
+		built[dockerConfigJSONKey] = config
+	}
+	if plugin.TLS.assembles() {
+		certificate, privateKey, err := buildTLS(plugin.TLS, data)
+		if err != nil {
+			return nil, err
+		}
+		built[tlsCertificateKey] = certificate
+		built[tlsPrivateKeyKey] = privateKey
+	}
+	if plugin.BasicAuth != (BasicAuthOptions{}) {
+		credentials, err := buildBasicAuth(plugin.BasicAuth, data)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range credentials {
+			built[key] = value
+		}
+	}
+	if len(plugin.Htpasswd.Users) > 0 {
+		file, err := buildHtpasswd(plugin, data)
+		if err != nil {
+			return nil, err
+		}
+		built[plugin.Htpasswd.key()] = file
+	}
+	for _, keystore := range plugin.Keystores {
+		content, err := buildKeystore(plugin, keystore, data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to build keystore '%s'", keystore.Key)
+		}
+		built[keystore.Key] = content
+	}
+
+	data = consumeBuilderKeys(plugin, data)
+	for key, value := range built {
+		data[key] = base64.StdEncoding.EncodeToString(value)
+	}
+	return data, nil
+}
+
+// mergedTypedKeys are the keys validateTypedData needs when merging into an existing secret
+var mergedTypedKeys = map[string][]string{
+	typeDockerConfigJSON: {dockerConfigJSONKey},
+	typeTLS:              {tlsCertificateKey, tlsPrivateKeyKey},
+	typeBasicAuth:        {basicAuthUsernameKey, basicAuthPasswordKey},
+}
+
+// validateTypedData validates the data required by the secret type. When merging into an existing secret,
+// which may hold some of the keys already, the data is only validated if it has all keys the check needs.
+func validateTypedData(plugin *KGCPSecret, secretType string, data kvMap) error {
+	if plugin.Behavior == "merge" {
+		for _, key := range mergedTypedKeys[secretType] {
+			if _, ok := data[key]; !ok {
+				return nil
+			}
+		}
+	}
+	switch secretType {
+	case typeDockerConfigJSON:
+		return validateDockerConfigJSON(data)
+	case typeTLS:
+		return validateTLS(plugin, data)
+	case typeBasicAuth:
+		return validateBasicAuth(data)
+	}
+	return nil
+}
+
+// consumeBuilderKeys leaves out the values only read by the builder
+func consumeBuilderKeys(plugin *KGCPSecret, data kvMap) kvMap {
+	kept := make(kvMap)
+	for key, value := range data {
+		if containsKey(plugin.Keys, key) || !containsKey(plugin.builderKeys(), key) {
+			kept[key] = value
+		}
+	}
+	return kept
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// decodedValue returns the value of a key read by a builder
+func decodedValue(data kvMap, key string) ([]byte, error) {
+	value, ok := data[key]
+	if !ok {
+		return nil, errors.Errorf("key '%s' has no value", key)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "value of key '%s' is not base64 encoded", key)
+	}
+	return decoded, nil
+}
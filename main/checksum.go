@@ -0,0 +1,78 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumError reports a payload which does not match the CRC32C checksum calculated by Secret Manager
+type checksumError struct {
+	name     string
+	expected int64
+	actual   uint32
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("payload of secret %s is corrupted: expected CRC32C checksum %d, got %d", e.name, e.expected, e.actual)
+}
+
+func (e *checksumError) stopsLookup() {}
+
+func (e *checksumError) category() string {
+	return categoryCorrupted
+}
+
+// accessGCPSecretVersion reads a secret version and verifies the checksum of its payload.
+// A corrupted payload is read a second time before giving up.
+func accessGCPSecretVersion(ctx context.Context, client *secretmanager.Client, caller *gcpCaller,
+	name string) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var secret *secretmanagerpb.AccessSecretVersionResponse
+		accessErr := caller.call(ctx, "access secret version "+name, func(ctx context.Context) (err error) {
+			secret, err = client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: name}, noClientRetry)
+			return
+		})
+		if accessErr != nil {
+			return nil, accessErr
+		}
+		if err = verifyPayloadChecksum(secret.GetName(), secret.GetPayload()); err == nil {
+			return secret, nil
+		}
+	}
+	return nil, err
+}
+
+// verifyPayloadChecksum compares the data of the payload to its CRC32C checksum, if Secret Manager provided one
+func verifyPayloadChecksum(name string, payload *secretmanagerpb.SecretPayload) error {
+	if payload.DataCrc32C == nil {
+		return nil
+	}
+	checksum := crc32.Checksum(payload.GetData(), crc32cTable)
+	if int64(checksum) != payload.GetDataCrc32C() {
+		return &checksumError{name: name, expected: payload.GetDataCrc32C(), actual: checksum}
+	}
+	return nil
+}
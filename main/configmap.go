@@ -0,0 +1,54 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+// K8SConfigMap is a Kubernetes ConfigMap
+type K8SConfigMap struct {
+	TypeMeta   `json:",inline" yaml:",inline"`
+	ObjectMeta `json:"metadata" yaml:"metadata"`
+	Data       kvMap `json:"data,omitempty" yaml:"data,omitempty"`
+	BinaryData kvMap `json:"binaryData,omitempty" yaml:"binaryData,omitempty"`
+}
+
+// GetConfigMap gets the data out of Google Secret Manager like GetSecrets, but creates a Kubernetes ConfigMap
+// with the plain text values. Values that are not valid UTF-8 go into binaryData.
+func GetConfigMap(ctx context.Context, client *secretmanager.Client,
+	plugin *KGCPSecret, listGCPSecrets secretsGetter, getGCPSecretValue secretValueGetter) (K8SConfigMap, error) {
+	values, err := createGCPSecretValuesGetter(plugin, listGCPSecrets)(ctx, client, plugin, getGCPSecretValue)
+	if err != nil {
+		return K8SConfigMap{}, err
+	}
+
+	configMap := K8SConfigMap{
+		TypeMeta: TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: generatedObjectMeta(plugin),
+	}
+	configMap.Data, configMap.BinaryData, err = splitTextValues(values)
+	if err != nil {
+		return K8SConfigMap{}, err
+	}
+	return configMap, nil
+}
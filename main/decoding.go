@@ -0,0 +1,221 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const apiVersion = "metro.digital/v1"
+
+// serverManagedFields are the fields of the Kubernetes ObjectMeta only the API server sets
+var serverManagedFields = []string{
+	"uid", "resourceVersion", "generation", "creationTimestamp", "deletionTimestamp", "deletionGracePeriodSeconds",
+	"managedFields", "selfLink",
+}
+
+var unknownFieldError = regexp.MustCompile(`^line (\d+): field (\S+) not found in type (\S+)$`)
+
+// parseInput decodes a KGCPSecret strictly and validates it
+func parseInput(content []byte) (KGCPSecret, error) {
+	input := KGCPSecret{
+		TypeMeta: TypeMeta{},
+		GCPObjectMeta: GCPObjectMeta{
+			Annotations: make(metadataMap),
+		},
+	}
+	if err := yaml.UnmarshalStrict(content, &input); err != nil {
+		return KGCPSecret{}, decodingError(err)
+	}
+	if input.APIVersion != apiVersion {
+		return KGCPSecret{}, errors.Errorf("apiVersion must be '%s', got '%s'", apiVersion, input.APIVersion)
+	}
+	if input.Kind != kindSecret && input.Kind != kindConfigMap {
+		return KGCPSecret{}, errors.Errorf("kind must be '%s' or '%s', got '%s'", kindSecret, kindConfigMap, input.Kind)
+	}
+	if len(input.Keys) == 0 && len(input.KeySelectors) == 0 && len(input.builderKeys()) == 0 {
+		return KGCPSecret{}, errors.New("input must contain keys, keySelectors or a builder reading keys")
+	}
+	for i, key := range input.Keys {
+		if key == "" {
+			return KGCPSecret{}, errors.Errorf("keys entry %d is empty", i+1)
+		}
+	}
+	return input, nil
+}
+
+// decodingError lists the problems found while decoding, suggesting the field meant for unknown fields
+func decodingError(err error) error {
+	typeError, ok := err.(*yaml.TypeError)
+	if !ok {
+		return errors.Wrap(err, "invalid KGCPSecret")
+	}
+	fields := yamlFields(reflect.TypeOf(KGCPSecret{}), map[string][]string{})
+	messages := make([]string, 0, len(typeError.Errors))
+	for _, message := range typeError.Errors {
+		match := unknownFieldError.FindStringSubmatch(message)
+		switch {
+		case match == nil:
+		case match[3] == "main.GCPObjectMeta" && containsKey(serverManagedFields, match[2]):
+			message = fmt.Sprintf("line %s: field 'metadata.%s' is set by the Kubernetes API server and cannot be set",
+				match[1], match[2])
+		default:
+			message = fmt.Sprintf("line %s: unknown field '%s'", match[1], match[2])
+			if suggestion := suggestField(match[2], fields[match[3]]); suggestion != "" {
+				message += fmt.Sprintf(", did you mean '%s'?", suggestion)
+			}
+		}
+		messages = append(messages, "- "+message)
+	}
+	return errors.Errorf("invalid KGCPSecret:\n%s", strings.Join(messages, "\n"))
+}
+
+// yamlFields collects the yaml field names of the struct types reachable from the type, by type name
+func yamlFields(t reflect.Type, fields map[string][]string) map[string][]string {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		return yamlFields(t.Elem(), fields)
+	case reflect.Struct:
+	default:
+		return fields
+	}
+	if _, ok := fields[t.String()]; ok {
+		return fields
+	}
+	fields[t.String()] = nil
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if tag[0] == "" && len(tag) > 1 && tag[1] == "inline" {
+			if field.Type.Kind() == reflect.Struct {
+				fields[t.String()] = append(fields[t.String()], yamlFields(field.Type, fields)[field.Type.String()]...)
+			}
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[t.String()] = append(fields[t.String()], name)
+		yamlFields(field.Type, fields)
+	}
+	return fields
+}
+
+// suggestField returns the known field closest to the unknown one, if it is close enough to be a typo
+func suggestField(unknown string, known []string) string {
+	suggestion, best := "", 3
+	for _, field := range known {
+		distance := editDistance(strings.ToLower(unknown), strings.ToLower(field))
+		if distance < best {
+			suggestion, best = field, distance
+		}
+	}
+	return suggestion
+}
+
+// editDistance returns the Levenshtein distance of two strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
+
+// warnDeprecations warns about deprecated fields set in the KGCPSecret
+func (p *KGCPSecret) warnDeprecations() {
+	if p.Stage != "" {
+		p.warn("metadata.stage is deprecated, use metadata.environment instead")
+	}
+	if p.Dc != "" {
+		p.warn("metadata.dc is deprecated, use metadata.tag instead")
+	}
+}
+
+// unknownKeyOptions describes the keys of keyOptions which are neither keys nor read by a builder,
+// suggesting the key meant if it looks like a typo
+func (p *KGCPSecret) unknownKeyOptions() []string {
+	keys := p.withBuilderKeys().Keys
+	var messages []string
+	for key := range p.KeyOptions {
+		if containsKey(keys, key) {
+			continue
+		}
+		message := fmt.Sprintf("key '%s' is not one of the keys", key)
+		if suggestion := suggestField(key, keys); suggestion != "" {
+			message += fmt.Sprintf(", did you mean '%s'?", suggestion)
+		}
+		messages = append(messages, message)
+	}
+	sort.Strings(messages)
+	return messages
+}
+
+// validateKeyOptions fails for keyOptions of unknown keys, unless keySelectors may select them
+func (p *KGCPSecret) validateKeyOptions() error {
+	messages := p.unknownKeyOptions()
+	if len(messages) == 0 || len(p.KeySelectors) > 0 {
+		return nil
+	}
+	for i, message := range messages {
+		messages[i] = "- " + message
+	}
+	return errors.Errorf("invalid keyOptions:\n%s", strings.Join(messages, "\n"))
+}
+
+// warnKeyOptions warns about keyOptions of keys that are only known once keySelectors selected the keys
+func (p *KGCPSecret) warnKeyOptions() {
+	if len(p.KeySelectors) == 0 {
+		return
+	}
+	for _, message := range p.unknownKeyOptions() {
+		p.warn(fmt.Sprintf("keyOptions %s, it is only used if keySelectors select it", message))
+	}
+}
@@ -0,0 +1,89 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// validateDefaults checks the default settings of a key
+func (o KeyOptions) validateDefaults(plugin *KGCPSecret, key string) error {
+	if o.DefaultFrom == "" {
+		return nil
+	}
+	if o.Default != nil {
+		return errors.New("only one of default and defaultFrom can be set")
+	}
+	if o.DefaultFrom == key {
+		return errors.New("defaultFrom must name another key")
+	}
+	for _, k := range plugin.Keys {
+		if k == o.DefaultFrom {
+			return nil
+		}
+	}
+	return errors.Errorf("defaultFrom key '%s' is not one of the keys", o.DefaultFrom)
+}
+
+// applyKeyDefaults sets the values of keys that were not found in Secret Manager to their defaults
+// and leaves out optional keys. It returns the errors of the keys that still have no value.
+func applyKeyDefaults(plugin *KGCPSecret, secrets kvMap, keyErrors secretErrors) secretErrors {
+	pending := keyErrors
+	for {
+		// a key can take its default from a key that itself got a default, so repeat until nothing changes
+		var remaining secretErrors
+		for _, keyErr := range pending {
+			options := plugin.KeyOptions[keyErr.Key]
+			if keyErr.Category != categoryNotFound {
+				remaining = append(remaining, keyErr)
+				continue
+			}
+			if options.Default != nil {
+				secrets[keyErr.Key] = base64.StdEncoding.EncodeToString([]byte(*options.Default))
+				plugin.explanation.recordDefault(keyErr.Key, "default value")
+				continue
+			}
+			if value, ok := secrets[options.DefaultFrom]; ok && options.DefaultFrom != "" {
+				secrets[keyErr.Key] = value
+				plugin.explanation.recordDefault(keyErr.Key, fmt.Sprintf("value of key %s", options.DefaultFrom))
+				continue
+			}
+			remaining = append(remaining, keyErr)
+		}
+		if len(remaining) == len(pending) {
+			break
+		}
+		pending = remaining
+	}
+
+	var failed secretErrors
+	for _, keyErr := range pending {
+		options := plugin.KeyOptions[keyErr.Key]
+		if keyErr.Category == categoryNotFound && options.Optional {
+			plugin.explanation.recordDefault(keyErr.Key, "optional, left out")
+			continue
+		}
+		if keyErr.Category == categoryNotFound && options.DefaultFrom != "" {
+			keyErr.err = fmt.Errorf("%v and defaultFrom key '%s' has no value either", keyErr.err, options.DefaultFrom)
+		}
+		failed = append(failed, keyErr)
+	}
+	return failed
+}
@@ -0,0 +1,103 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const dockerConfigJSONKey = ".dockerconfigjson"
+
+// Registry describes the credentials of a container registry in a kubernetes.io/dockerconfigjson secret
+type Registry struct {
+	Server      string `json:"server" yaml:"server"`
+	UsernameKey string `json:"usernameKey" yaml:"usernameKey"`
+	PasswordKey string `json:"passwordKey" yaml:"passwordKey"`
+	Email       string `json:"email,omitempty" yaml:"email,omitempty"`
+}
+
+// dockerConfig is the content of the .dockerconfigjson key
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+func (r Registry) validate() error {
+	if r.Server == "" {
+		return errors.New("server must be set")
+	}
+	if r.UsernameKey == "" || r.PasswordKey == "" {
+		return errors.Errorf("usernameKey and passwordKey must be set for server '%s'", r.Server)
+	}
+	return nil
+}
+
+// buildDockerConfigJSON creates the .dockerconfigjson for the registries from the resolved credentials
+func buildDockerConfigJSON(registries []Registry, data kvMap) ([]byte, error) {
+	config := dockerConfig{Auths: make(map[string]dockerAuth)}
+	for _, registry := range registries {
+		if _, ok := config.Auths[registry.Server]; ok {
+			return nil, errors.Errorf("registry server '%s' is listed more than once", registry.Server)
+		}
+		username, err := decodedValue(data, registry.UsernameKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "no username for registry '%s'", registry.Server)
+		}
+		password, err := decodedValue(data, registry.PasswordKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "no password for registry '%s'", registry.Server)
+		}
+		config.Auths[registry.Server] = dockerAuth{
+			Username: string(username),
+			Password: string(password),
+			Email:    registry.Email,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(string(username) + ":" + string(password))),
+		}
+	}
+	return json.Marshal(config)
+}
+
+// validateDockerConfigJSON checks that the secret contains a .dockerconfigjson with credentials
+// for every registry
+func validateDockerConfigJSON(data kvMap) error {
+	content, err := decodedValue(data, dockerConfigJSONKey)
+	if err != nil {
+		return errors.Wrapf(err, "a secret of type %s needs the key %s", typeDockerConfigJSON, dockerConfigJSONKey)
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return errors.Wrapf(err, "%s is not valid JSON", dockerConfigJSONKey)
+	}
+	if len(config.Auths) == 0 {
+		return errors.Errorf("%s has no auths", dockerConfigJSONKey)
+	}
+	for server, auth := range config.Auths {
+		if auth.Auth == "" && (auth.Username == "" || auth.Password == "") {
+			return errors.Errorf("%s has no credentials for registry '%s'", dockerConfigJSONKey, server)
+		}
+	}
+	return nil
+}
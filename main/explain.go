@@ -0,0 +1,110 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+)
+
+// explanation records which secret and version was used for each key of a KGCPSecret.
+// All methods can be called on a nil explanation, which records nothing.
+type explanation struct {
+	keys     []string
+	selected []string
+	secrets  map[string]string
+	versions map[string]*secretmanagerpb.AccessSecretVersionResponse
+	defaults map[string]string
+}
+
+func newExplanation() *explanation {
+	return &explanation{
+		secrets:  make(map[string]string),
+		versions: make(map[string]*secretmanagerpb.AccessSecretVersionResponse),
+		defaults: make(map[string]string),
+	}
+}
+
+// recordKeys remembers all keys of the secret and the ones that were added by keySelectors
+func (e *explanation) recordKeys(keys []string, selected []string) {
+	if e == nil {
+		return
+	}
+	e.keys = keys
+	e.selected = selected
+}
+
+// recordKey remembers the secret the value of a key was taken from
+func (e *explanation) recordKey(key string, secret string) {
+	if e == nil {
+		return
+	}
+	e.secrets[key] = secret
+}
+
+// recordDefault remembers that a key was not found and how its value was set instead
+func (e *explanation) recordDefault(key string, description string) {
+	if e == nil {
+		return
+	}
+	e.defaults[key] = description
+}
+
+// recordVersion remembers the version that was read for a secret
+func (e *explanation) recordVersion(secret string, version *secretmanagerpb.AccessSecretVersionResponse) {
+	if e == nil {
+		return
+	}
+	e.versions[secret] = version
+}
+
+// resolvedVersion returns the id of the version that was read for a secret, or "" if it is unknown
+func (e *explanation) resolvedVersion(secret string) string {
+	name := e.versions[secret].GetName()
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+func (e *explanation) write(w io.Writer) {
+	if e == nil {
+		return
+	}
+	if len(e.selected) > 0 {
+		_, _ = fmt.Fprintf(w, "keys selected by keySelectors: %s\n", strings.Join(e.selected, ", "))
+	}
+	for _, key := range e.keys {
+		if description, ok := e.defaults[key]; ok {
+			_, _ = fmt.Fprintf(w, "%s: %s\n", key, description)
+			continue
+		}
+		secret, ok := e.secrets[key]
+		if !ok {
+			_, _ = fmt.Fprintf(w, "%s: not resolved\n", key)
+			continue
+		}
+		line := fmt.Sprintf("%s: secret %s", key, secret)
+		if version, ok := e.versions[secret]; ok {
+			line += fmt.Sprintf(", version %s", version.GetName())
+			if version.GetPayload().DataCrc32C != nil {
+				line += fmt.Sprintf(", crc32c %d", version.GetPayload().GetDataCrc32C())
+			}
+		}
+		_, _ = fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,141 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+)
+
+// Internal functions exposed to the unit tests in package main_test
+
+var SelectSecretVersion = selectSecretVersion
+
+var SecretVersionAt = secretVersionAt
+
+func VersionCutoff(plugin *KGCPSecret, key string) (time.Time, error) {
+	return plugin.forKey(key).versionCutoff()
+}
+
+func NewVersionStateError(secret string, message string) error {
+	return &versionStateError{secret: secret, message: message}
+}
+
+var VerifyPayloadChecksum = verifyPayloadChecksum
+
+// EnableExplanation records the resolution of the keys and returns a function writing it
+func EnableExplanation(plugin *KGCPSecret) func(io.Writer) {
+	plugin.explanation = newExplanation()
+	return func(w io.Writer) {
+		plugin.explanation.write(w)
+	}
+}
+
+// NewGCPCaller returns a function running calls with the retry policy and rate limit of the KGCPSecret
+func NewGCPCaller(plugin *KGCPSecret) (func(operation string, f func(ctx context.Context) error) error, error) {
+	caller, err := newGCPCaller(plugin)
+	if err != nil {
+		return nil, err
+	}
+	return func(operation string, f func(ctx context.Context) error) error {
+		return caller.call(context.Background(), operation, f)
+	}, nil
+}
+
+// RecordSecretVersion records the version read for a secret like the Secret Manager lookup does
+func RecordSecretVersion(plugin *KGCPSecret, secret string, name string) {
+	plugin.explanation.recordVersion(secret, &secretmanagerpb.AccessSecretVersionResponse{Name: name})
+}
+
+// UseLockfile reads the lockfile the plugin locks the versions in
+func UseLockfile(plugin *KGCPSecret, fn string) (err error) {
+	plugin.lock, err = readLockfile(fn)
+	return
+}
+
+// WriteLockfile writes the versions locked by the plugin
+func WriteLockfile(plugin *KGCPSecret, fn string) error {
+	return plugin.lock.write(fn)
+}
+
+// LockedVersion returns the version locked for a secret
+func LockedVersion(plugin *KGCPSecret, parent string) (string, bool) {
+	return plugin.lock.lockedVersion(parent)
+}
+
+// LockSecretVersion locks a version read for a secret like the Secret Manager lookup does
+func LockSecretVersion(plugin *KGCPSecret, parent string, name string, data string) error {
+	return plugin.lock.record(parent, &secretmanagerpb.AccessSecretVersionResponse{
+		Name:    name,
+		Payload: &secretmanagerpb.SecretPayload{Data: []byte(data)},
+	})
+}
+
+// LockSecretReference locks a version resolved without its payload like the outputs referencing the secrets do
+func LockSecretReference(plugin *KGCPSecret, parent string, name string) error {
+	return plugin.lock.record(parent, &secretmanagerpb.AccessSecretVersionResponse{Name: name})
+}
+
+// ReadsPayloads tells whether the plugin reads the values of the secrets
+func ReadsPayloads(plugin *KGCPSecret) bool {
+	return plugin.readsPayloads()
+}
+
+var LockedVersionError = lockedVersionError
+
+var StopsLookup = stopsLookup
+
+// SetWarnings redirects the warnings of the plugin
+func SetWarnings(plugin *KGCPSecret, w io.Writer) {
+	plugin.warnings = w
+}
+
+var WriteErrorReport = writeErrorReport
+
+// SetLabeledSecretsLister replaces the Secret Manager lookup of label selectors
+func SetLabeledSecretsLister(plugin *KGCPSecret, f func(projectID string, filter string) ([]string, error)) {
+	plugin.listLabeledSecrets = f
+}
+
+var ParseInput = parseInput
+
+var ReadInput = readInput
+
+// WarnDeprecations warns about the deprecated fields of the plugin
+func WarnDeprecations(plugin *KGCPSecret) {
+	plugin.warnDeprecations()
+}
+
+// ValidateKeyOptions validates the keys of the keyOptions like reading the input does
+func ValidateKeyOptions(plugin *KGCPSecret) error {
+	return plugin.validateKeyOptions()
+}
+
+// WarnKeyOptions warns about the keyOptions of keys the plugin may not select
+func WarnKeyOptions(plugin *KGCPSecret) {
+	plugin.warnKeyOptions()
+}
+
+// ValidateTransforms validates the transforms of the key like reading the input does
+func ValidateTransforms(plugin *KGCPSecret, key string) error {
+	return plugin.KeyOptions[key].validateTransforms(plugin.output())
+}
@@ -0,0 +1,119 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+// ExternalSecretOptions configures the ExternalSecret generated with output externalsecret
+type ExternalSecretOptions struct {
+	SecretStoreRef  SecretStoreRef `json:"secretStoreRef" yaml:"secretStoreRef"`
+	RefreshInterval string         `json:"refreshInterval,omitempty" yaml:"refreshInterval,omitempty"`
+}
+
+// SecretStoreRef references the SecretStore or ClusterSecretStore of the External Secrets Operator
+type SecretStoreRef struct {
+	Name string `json:"name" yaml:"name"`
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+}
+
+// K8SExternalSecret is an ExternalSecret of the External Secrets Operator
+type K8SExternalSecret struct {
+	TypeMeta   `json:",inline" yaml:",inline"`
+	ObjectMeta `json:"metadata" yaml:"metadata"`
+	Spec       ExternalSecretSpec `json:"spec" yaml:"spec"`
+}
+
+// ExternalSecretSpec is the spec of an ExternalSecret
+type ExternalSecretSpec struct {
+	RefreshInterval string               `json:"refreshInterval,omitempty" yaml:"refreshInterval,omitempty"`
+	SecretStoreRef  SecretStoreRef       `json:"secretStoreRef" yaml:"secretStoreRef"`
+	Target          ExternalSecretTarget `json:"target" yaml:"target"`
+	Data            []ExternalSecretData `json:"data" yaml:"data"`
+}
+
+// ExternalSecretTarget describes the Kubernetes Secret created by the External Secrets Operator
+type ExternalSecretTarget struct {
+	Name     string                  `json:"name" yaml:"name"`
+	Template *ExternalSecretTemplate `json:"template,omitempty" yaml:"template,omitempty"`
+}
+
+// ExternalSecretTemplate sets the type of the Kubernetes Secret created by the External Secrets Operator
+type ExternalSecretTemplate struct {
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+// ExternalSecretData maps a key of the Kubernetes Secret to a secret in Google Secret Manager
+type ExternalSecretData struct {
+	SecretKey string                  `json:"secretKey" yaml:"secretKey"`
+	RemoteRef ExternalSecretRemoteRef `json:"remoteRef" yaml:"remoteRef"`
+}
+
+// ExternalSecretRemoteRef references a secret version in Google Secret Manager
+type ExternalSecretRemoteRef struct {
+	Key     string `json:"key" yaml:"key"`
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+}
+
+// GetExternalSecret resolves the keys like GetSecrets, but creates an ExternalSecret referencing the resolved
+// secrets and versions instead of a Kubernetes Secret holding their values
+func GetExternalSecret(ctx context.Context, client *secretmanager.Client,
+	plugin *KGCPSecret, listGCPSecrets secretsGetter, getGCPSecretValue secretValueGetter) (K8SExternalSecret, error) {
+	if plugin.explanation == nil {
+		// the resolved secrets and versions are taken from the explanation
+		plugin.explanation = newExplanation()
+	}
+	values, err := createGCPSecretValuesGetter(plugin, listGCPSecrets)(ctx, client, plugin, getGCPSecretValue)
+	if err != nil {
+		return K8SExternalSecret{}, err
+	}
+
+	options := plugin.ExternalSecret
+	externalSecret := K8SExternalSecret{
+		TypeMeta: TypeMeta{
+			APIVersion: "external-secrets.io/v1beta1",
+			Kind:       "ExternalSecret",
+		},
+		ObjectMeta: pluginObjectMeta(plugin),
+		Spec: ExternalSecretSpec{
+			RefreshInterval: options.RefreshInterval,
+			SecretStoreRef:  options.SecretStoreRef,
+			Target:          ExternalSecretTarget{Name: plugin.Name},
+			Data:            []ExternalSecretData{},
+		},
+	}
+	if plugin.Type != "" {
+		externalSecret.Spec.Target.Template = &ExternalSecretTemplate{Type: plugin.Type}
+	}
+
+	references, err := secretReferences(plugin, values, "an ExternalSecret")
+	if err != nil {
+		return K8SExternalSecret{}, err
+	}
+	for _, reference := range references {
+		// the version resolved is pinned like for a SecretProviderClass, so all outputs render the same values
+		remoteRef := ExternalSecretRemoteRef{
+			Key:     sanitizeKeyName(reference.secret),
+			Version: plugin.explanation.resolvedVersion(reference.secret),
+		}
+		externalSecret.Spec.Data = append(externalSecret.Spec.Data, ExternalSecretData{SecretKey: reference.key, RemoteRef: remoteRef})
+	}
+	return externalSecret, nil
+}
@@ -0,0 +1,159 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blowfish"
+)
+
+const (
+	defaultHtpasswdKey   = "auth"
+	defaultBcryptCost    = 10
+	minBcryptCost        = 4
+	maxBcryptCost        = 31
+	maxBcryptPasswordLen = 72
+)
+
+// magicCipherData is "OrpheanBeholderScryDoubt", the text bcrypt encrypts
+var magicCipherData = []byte("OrpheanBeholderScryDoubt")
+
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").
+	WithPadding(base64.NoPadding)
+
+// HtpasswdOptions generates an htpasswd file with bcrypt hashes of the resolved credentials
+type HtpasswdOptions struct {
+	Key               string         `json:"key,omitempty" yaml:"key,omitempty"`
+	Users             []HtpasswdUser `json:"users,omitempty" yaml:"users,omitempty"`
+	Cost              int            `json:"cost,omitempty" yaml:"cost,omitempty"`
+	DeterministicSalt bool           `json:"deterministicSalt,omitempty" yaml:"deterministicSalt,omitempty"`
+}
+
+// HtpasswdUser is a user of the htpasswd file
+type HtpasswdUser struct {
+	UsernameKey string `json:"usernameKey" yaml:"usernameKey"`
+	PasswordKey string `json:"passwordKey" yaml:"passwordKey"`
+}
+
+func (o HtpasswdOptions) configured() bool {
+	return len(o.Users) > 0 || o.Key != "" || o.Cost != 0 || o.DeterministicSalt
+}
+
+func (o HtpasswdOptions) key() string {
+	if o.Key != "" {
+		return o.Key
+	}
+	return defaultHtpasswdKey
+}
+
+func (o HtpasswdOptions) cost() int {
+	if o.Cost != 0 {
+		return o.Cost
+	}
+	return defaultBcryptCost
+}
+
+func (o HtpasswdOptions) keys() []string {
+	var keys []string
+	for _, user := range o.Users {
+		keys = append(keys, user.UsernameKey, user.PasswordKey)
+	}
+	return keys
+}
+
+func (o HtpasswdOptions) validate() error {
+	if len(o.Users) == 0 {
+		return errors.New("htpasswd needs at least one user")
+	}
+	for i, user := range o.Users {
+		if user.UsernameKey == "" || user.PasswordKey == "" {
+			return errors.Errorf("usernameKey and passwordKey must be set for htpasswd user %d", i+1)
+		}
+	}
+	if o.cost() < minBcryptCost || o.cost() > maxBcryptCost {
+		return errors.Errorf("htpasswd.cost must be between %d and %d, got %d", minBcryptCost, maxBcryptCost, o.Cost)
+	}
+	return nil
+}
+
+// buildHtpasswd creates an htpasswd file with a line for every user. With deterministic salts the salt is
+// derived from the secret and the username, so the hashes only change when the password changes.
+func buildHtpasswd(plugin *KGCPSecret, data kvMap) ([]byte, error) {
+	options := plugin.Htpasswd
+	var file bytes.Buffer
+	for _, user := range options.Users {
+		username, err := decodedValue(data, user.UsernameKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "no htpasswd username")
+		}
+		if len(username) == 0 || bytes.ContainsAny(username, ":\r\n") {
+			return nil, errors.Errorf("htpasswd username of key '%s' must not be empty or contain ':' or line breaks", user.UsernameKey)
+		}
+		password, err := decodedValue(data, user.PasswordKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "no htpasswd password for user '%s'", username)
+		}
+		if len(password) > maxBcryptPasswordLen {
+			return nil, errors.Errorf("htpasswd password of user '%s' is longer than %d bytes", username, maxBcryptPasswordLen)
+		}
+		salt := make([]byte, 16)
+		if options.DeterministicSalt {
+			derived := sha256.Sum256([]byte(fmt.Sprintf("htpasswd\x00%s/%s\x00%s", plugin.Namespace, plugin.Name, username)))
+			copy(salt, derived[:])
+		} else if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, err
+		}
+		hash, err := bcryptHash(password, options.cost(), salt)
+		if err != nil {
+			return nil, err
+		}
+		file.Write(username)
+		file.WriteString(":")
+		file.WriteString(hash)
+		file.WriteString("\n")
+	}
+	return file.Bytes(), nil
+}
+
+// bcryptHash hashes the password with the given salt like htpasswd -B
+func bcryptHash(password []byte, cost int, salt []byte) (string, error) {
+	// C implementations use the trailing NULL of the key
+	key := append(append([]byte{}, password...), 0)
+	c, err := blowfish.NewSaltedCipher(key, salt)
+	if err != nil {
+		return "", err
+	}
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		blowfish.ExpandKey(key, c)
+		blowfish.ExpandKey(salt, c)
+	}
+	cipherData := append([]byte{}, magicCipherData...)
+	for i := 0; i < 24; i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(cipherData[i:i+8], cipherData[i:i+8])
+		}
+	}
+	// like C implementations, only 23 of the 24 encrypted bytes are encoded
+	return fmt.Sprintf("$2y$%02d$%s%s", cost, bcryptEncoding.EncodeToString(salt), bcryptEncoding.EncodeToString(cipherData[:23])), nil
+}
@@ -0,0 +1,114 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// limits of the Kubernetes API server
+const (
+	maxSecretSize        = 1024 * 1024
+	maxSubdomainLength   = 253
+	maxLabelLength       = 63
+	nameSuffixHashLength = 11
+)
+
+var (
+	dataKeyPattern   = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+	subdomainPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	labelPattern     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// requiredSecretKeys are the keys Kubernetes requires for the built-in secret types, one of them must exist
+var requiredSecretKeys = map[string][]string{
+	typeTLS:              {tlsCertificateKey},
+	typeDockerConfigJSON: {dockerConfigJSONKey},
+	typeDockerCfg:        {".dockercfg"},
+	typeSSHAuth:          {sshPrivateKeyKey},
+	typeBasicAuth:        {basicAuthUsernameKey, basicAuthPasswordKey},
+}
+
+// validateK8SSecret checks the secret against the rules of the Kubernetes API server and reports all violations
+func validateK8SSecret(secret K8SSecret) error {
+	var problems []string
+	maxNameLength := maxSubdomainLength
+	if secret.Annotations["kustomize.config.k8s.io/needs-hash"] == "true" {
+		// Kustomize appends a dash and a hash of ten characters
+		maxNameLength -= nameSuffixHashLength
+	}
+	if len(secret.Name) > maxNameLength || !subdomainPattern.MatchString(secret.Name) {
+		problems = append(problems, fmt.Sprintf("name '%s' must be a lowercase DNS subdomain of at most %d characters",
+			secret.Name, maxNameLength))
+	}
+	if secret.Namespace != "" && (len(secret.Namespace) > maxLabelLength || !labelPattern.MatchString(secret.Namespace)) {
+		problems = append(problems, fmt.Sprintf("namespace '%s' must be a lowercase DNS label of at most %d characters",
+			secret.Namespace, maxLabelLength))
+	}
+
+	size := 0
+	keys := make(map[string]bool)
+	for key, value := range secret.Data {
+		padding := len(value) - len(strings.TrimRight(value, "="))
+		size += base64.StdEncoding.DecodedLen(len(value)) - padding
+		keys[key] = true
+	}
+	for key, value := range secret.StringData {
+		size += len(value)
+		keys[key] = true
+	}
+	for _, key := range sortedKeys(keys) {
+		if len(key) > maxSubdomainLength || !dataKeyPattern.MatchString(key) || key == "." || key == ".." {
+			problems = append(problems, fmt.Sprintf("key '%s' must consist of alphanumeric characters, '-', '_' or '.'", key))
+		}
+	}
+	if size > maxSecretSize {
+		problems = append(problems, fmt.Sprintf("data has %d bytes, more than the limit of %d bytes", size, maxSecretSize))
+	}
+
+	// merged into an existing secret, which may hold the keys already
+	merged := secret.Annotations["kustomize.config.k8s.io/behavior"] == "merge"
+	if required, ok := requiredSecretKeys[secret.Type]; ok && !merged {
+		found := false
+		for _, key := range required {
+			found = found || keys[key]
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("type %s needs the key %s", secret.Type, strings.Join(required, " or ")))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.Errorf("secret '%s' would be rejected by Kubernetes:\n- %s", secret.Name, strings.Join(problems, "\n- "))
+}
+
+func sortedKeys(keys map[string]bool) []string {
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,232 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	jks "github.com/pavlo-v-chernykh/keystore-go/v4"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// formats of keystores
+const (
+	keystoreFormatPKCS12 = "pkcs12"
+	keystoreFormatJKS    = "jks"
+)
+
+const defaultKeystoreAlias = "mykey"
+
+// Keystore describes a Java keystore or truststore built from PEM encoded certificates and keys
+type Keystore struct {
+	Key            string `json:"key" yaml:"key"`
+	Format         string `json:"format,omitempty" yaml:"format,omitempty"`
+	CertificateKey string `json:"certificateKey" yaml:"certificateKey"`
+	ChainKey       string `json:"chainKey,omitempty" yaml:"chainKey,omitempty"`
+	PrivateKeyKey  string `json:"privateKeyKey,omitempty" yaml:"privateKeyKey,omitempty"`
+	PasswordKey    string `json:"passwordKey" yaml:"passwordKey"`
+	Alias          string `json:"alias,omitempty" yaml:"alias,omitempty"`
+}
+
+func (k Keystore) keys() []string {
+	var keys []string
+	for _, key := range []string{k.CertificateKey, k.ChainKey, k.PrivateKeyKey, k.PasswordKey} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (k Keystore) format() string {
+	if k.Format != "" {
+		return k.Format
+	}
+	return keystoreFormatPKCS12
+}
+
+func (k Keystore) alias() string {
+	if k.Alias != "" {
+		return k.Alias
+	}
+	return defaultKeystoreAlias
+}
+
+func (k Keystore) validate() error {
+	if k.Key == "" {
+		return errors.New("key must be set")
+	}
+	if k.CertificateKey == "" || k.PasswordKey == "" {
+		return errors.Errorf("certificateKey and passwordKey must be set for keystore '%s'", k.Key)
+	}
+	switch k.format() {
+	case keystoreFormatPKCS12, keystoreFormatJKS:
+	default:
+		return errors.Errorf("format must be '%s' or '%s', got '%s'", keystoreFormatPKCS12, keystoreFormatJKS, k.Format)
+	}
+	return nil
+}
+
+// keystoreRandom returns the source of the salts and IVs of a keystore. It is derived from the secret and
+// the content of the keystore, so an unchanged keystore is built with the same bytes and the name suffix
+// hash of the secret does not change on every build.
+func keystoreRandom(plugin *KGCPSecret, keystore Keystore, content ...[]byte) io.Reader {
+	var secret []byte
+	for _, part := range content {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(part)))
+		secret = append(append(secret, length...), part...)
+	}
+	info := fmt.Sprintf("keystore\x00%s/%s\x00%s\x00%s\x00%s",
+		plugin.Namespace, plugin.Name, keystore.Key, keystore.format(), keystore.alias())
+	return hkdf.New(sha256.New, secret, nil, []byte(info))
+}
+
+// buildKeystore creates the keystore from the resolved values. Without a private key the certificates
+// are stored as trusted certificates.
+func buildKeystore(plugin *KGCPSecret, keystore Keystore, data kvMap) ([]byte, error) {
+	certificates, err := decodedValue(data, keystore.CertificateKey)
+	if err != nil {
+		return nil, err
+	}
+	if keystore.ChainKey != "" {
+		chain, err := decodedValue(data, keystore.ChainKey)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(append(certificates, '\n'), chain...)
+	}
+	password, err := decodedValue(data, keystore.PasswordKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if keystore.PrivateKeyKey == "" {
+		chain, err := parseCertificates(certificates)
+		if err != nil {
+			return nil, err
+		}
+		random := keystoreRandom(plugin, keystore, certificates, password)
+		if keystore.format() == keystoreFormatJKS {
+			return encodeJKSTrustStore(keystore, chain, password, random)
+		}
+		return pkcs12.EncodeTrustStore(random, chain, string(password))
+	}
+
+	privateKey, err := decodedValue(data, keystore.PrivateKeyKey)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(certificates, privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "certificate and private key are not a valid key pair")
+	}
+	chain, err := parseCertificates(certificates)
+	if err != nil {
+		return nil, err
+	}
+	random := keystoreRandom(plugin, keystore, certificates, privateKey, password)
+	if keystore.format() == keystoreFormatJKS {
+		return encodeJKSKeystore(keystore, pair, chain, password, random)
+	}
+	return pkcs12.Encode(random, pair.PrivateKey, chain[0], chain[1:], string(password))
+}
+
+// parseCertificates parses all PEM encoded certificates
+func parseCertificates(content []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid certificate")
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return certificates, nil
+}
+
+func encodeJKSKeystore(keystore Keystore, pair tls.Certificate, chain []*x509.Certificate, password []byte,
+	random io.Reader) ([]byte, error) {
+	privateKey, err := x509.MarshalPKCS8PrivateKey(pair.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	entry := jks.PrivateKeyEntry{
+		CreationTime: chain[0].NotBefore,
+		PrivateKey:   privateKey,
+	}
+	for _, certificate := range chain {
+		entry.CertificateChain = append(entry.CertificateChain, jks.Certificate{Type: "X509", Content: certificate.Raw})
+	}
+	store := newJKS(random)
+	if err := store.SetPrivateKeyEntry(keystore.alias(), entry, password); err != nil {
+		return nil, err
+	}
+	return storeJKS(store, password)
+}
+
+func encodeJKSTrustStore(keystore Keystore, certificates []*x509.Certificate, password []byte,
+	random io.Reader) ([]byte, error) {
+	store := newJKS(random)
+	for i, certificate := range certificates {
+		alias := keystore.alias()
+		if i > 0 {
+			alias = fmt.Sprintf("%s-%d", alias, i)
+		}
+		entry := jks.TrustedCertificateEntry{
+			CreationTime: certificate.NotBefore,
+			Certificate:  jks.Certificate{Type: "X509", Content: certificate.Raw},
+		}
+		if err := store.SetTrustedCertificateEntry(alias, entry); err != nil {
+			return nil, err
+		}
+	}
+	return storeJKS(store, password)
+}
+
+// newJKS creates a JKS keystore which writes its entries in a stable order
+func newJKS(random io.Reader) jks.KeyStore {
+	return jks.New(jks.WithOrderedAliases(), jks.WithCustomRandomNumberGenerator(random))
+}
+
+func storeJKS(store jks.KeyStore, password []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if err := store.Store(&out, password); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -19,14 +19,19 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"google.golang.org/api/iterator"
 	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
@@ -42,33 +47,86 @@ type TypeMeta struct {
 
 // ObjectMeta contains Kubernetes resource metadata such as the name
 type ObjectMeta struct {
-	Name        string `json:"name" yaml:"name"`
-	Namespace   string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
-	Labels      kvMap  `json:"labels,omitempty" yaml:"labels,omitempty"`
-	Annotations kvMap  `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Name            string           `json:"name" yaml:"name"`
+	Namespace       string           `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	GenerateName    string           `json:"generateName,omitempty" yaml:"generateName,omitempty"`
+	Labels          kvMap            `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations     kvMap            `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	OwnerReferences []OwnerReference `json:"ownerReferences,omitempty" yaml:"ownerReferences,omitempty"`
+	Finalizers      []string         `json:"finalizers,omitempty" yaml:"finalizers,omitempty"`
+}
+
+// OwnerReference identifies the Kubernetes object owning the generated resource
+type OwnerReference struct {
+	APIVersion         string `json:"apiVersion" yaml:"apiVersion"`
+	Kind               string `json:"kind" yaml:"kind"`
+	Name               string `json:"name" yaml:"name"`
+	UID                string `json:"uid" yaml:"uid"`
+	Controller         *bool  `json:"controller,omitempty" yaml:"controller,omitempty"`
+	BlockOwnerDeletion *bool  `json:"blockOwnerDeletion,omitempty" yaml:"blockOwnerDeletion,omitempty"`
 }
 
 // GCPObjectMeta contains the meta data for KGCPSecret
 type GCPObjectMeta struct {
-	Environment string `json:"environment" yaml:"environment"`
-	Tag         string `json:"tag" yaml:"tag"`
-	Dc          string `json:"dc" yaml:"dc"`
-	Stage       string `json:"stage" yaml:"stage"`
-	Name        string `json:"name" yaml:"name"`
-	Namespace   string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
-	Labels      kvMap  `json:"labels,omitempty" yaml:"labels,omitempty"`
-	Annotations kvMap  `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Environment string      `json:"environment" yaml:"environment"`
+	Tag         string      `json:"tag" yaml:"tag"`
+	Dc          string      `json:"dc" yaml:"dc"`
+	Stage       string      `json:"stage" yaml:"stage"`
+	Name        string      `json:"name" yaml:"name"`
+	Namespace   string      `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Labels      metadataMap `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations metadataMap `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	// the Kubernetes ObjectMeta fields a client may set, copied to the generated resource
+	GenerateName    string           `json:"generateName,omitempty" yaml:"generateName,omitempty"`
+	OwnerReferences []OwnerReference `json:"ownerReferences,omitempty" yaml:"ownerReferences,omitempty"`
+	Finalizers      []string         `json:"finalizers,omitempty" yaml:"finalizers,omitempty"`
 }
 
 // KGCPSecret is data used to generate a secret
 type KGCPSecret struct {
-	TypeMeta              `json:",inline" yaml:",inline"`
-	GCPObjectMeta         `json:"metadata" yaml:"metadata"`
-	GCPProjectID          string   `json:"gcpProjectID,omitempty" yaml:"gcpProjectID,omitempty"`
-	DisableNameSuffixHash bool     `json:"disableNameSuffixHash,omitempty" yaml:"disableNameSuffixHash,omitempty"`
-	Type                  string   `json:"type,omitempty" yaml:"type,omitempty"`
-	Behavior              string   `json:"behavior,omitempty" yaml:"behavior,omitempty"`
-	Keys                  []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	TypeMeta                 `json:",inline" yaml:",inline"`
+	GCPObjectMeta            `json:"metadata" yaml:"metadata"`
+	GCPProjectID             string                     `json:"gcpProjectID,omitempty" yaml:"gcpProjectID,omitempty"`
+	DisableNameSuffixHash    bool                       `json:"disableNameSuffixHash,omitempty" yaml:"disableNameSuffixHash,omitempty"`
+	Type                     string                     `json:"type,omitempty" yaml:"type,omitempty"`
+	Behavior                 string                     `json:"behavior,omitempty" yaml:"behavior,omitempty"`
+	Output                   string                     `json:"output,omitempty" yaml:"output,omitempty"`
+	StringData               bool                       `json:"stringData,omitempty" yaml:"stringData,omitempty"`
+	ExternalSecret           ExternalSecretOptions      `json:"externalSecret,omitempty" yaml:"externalSecret,omitempty"`
+	SealedSecret             SealedSecretOptions        `json:"sealedSecret,omitempty" yaml:"sealedSecret,omitempty"`
+	SOPS                     SOPSOptions                `json:"sops,omitempty" yaml:"sops,omitempty"`
+	SecretProviderClass      SecretProviderClassOptions `json:"secretProviderClass,omitempty" yaml:"secretProviderClass,omitempty"`
+	Keys                     []string                   `json:"keys,omitempty" yaml:"keys,omitempty"`
+	KeySelectors             []KeySelector              `json:"keySelectors,omitempty" yaml:"keySelectors,omitempty"`
+	AsOf                     string                     `json:"asOf,omitempty" yaml:"asOf,omitempty"`
+	MinVersionAge            string                     `json:"minVersionAge,omitempty" yaml:"minVersionAge,omitempty"`
+	FallbackToEnabledVersion bool                       `json:"fallbackToEnabledVersion,omitempty" yaml:"fallbackToEnabledVersion,omitempty"`
+	Retry                    RetryPolicy                `json:"retry,omitempty" yaml:"retry,omitempty"`
+	RequestsPerSecond        float64                    `json:"requestsPerSecond,omitempty" yaml:"requestsPerSecond,omitempty"`
+	RequireMatch             string                     `json:"requireMatch,omitempty" yaml:"requireMatch,omitempty"`
+	KeyOptions               map[string]KeyOptions      `json:"keyOptions,omitempty" yaml:"keyOptions,omitempty"`
+	Registries               []Registry                 `json:"registries,omitempty" yaml:"registries,omitempty"`
+	TLS                      TLSOptions                 `json:"tls,omitempty" yaml:"tls,omitempty"`
+	Keystores                []Keystore                 `json:"keystores,omitempty" yaml:"keystores,omitempty"`
+	BasicAuth                BasicAuthOptions           `json:"basicAuth,omitempty" yaml:"basicAuth,omitempty"`
+	Htpasswd                 HtpasswdOptions            `json:"htpasswd,omitempty" yaml:"htpasswd,omitempty"`
+
+	explanation        *explanation
+	lock               *lockfile
+	caller             *gcpCaller
+	listLabeledSecrets labeledSecretsGetter
+	warnings           io.Writer
+}
+
+// KeyOptions overrides settings of a KGCPSecret for a single key
+type KeyOptions struct {
+	MinVersionAge string     `json:"minVersionAge,omitempty" yaml:"minVersionAge,omitempty"`
+	RequireMatch  string     `json:"requireMatch,omitempty" yaml:"requireMatch,omitempty"`
+	Optional      bool       `json:"optional,omitempty" yaml:"optional,omitempty"`
+	Default       *string    `json:"default,omitempty" yaml:"default,omitempty"`
+	DefaultFrom   string     `json:"defaultFrom,omitempty" yaml:"defaultFrom,omitempty"`
+	Transforms    []string   `json:"transforms,omitempty" yaml:"transforms,omitempty"`
+	Validate      ValueRules `json:"validate,omitempty" yaml:"validate,omitempty"`
 }
 
 // K8SSecret is a Kubernetes Secret
@@ -76,28 +134,84 @@ type K8SSecret struct {
 	TypeMeta   `json:",inline" yaml:",inline"`
 	ObjectMeta `json:"metadata" yaml:"metadata"`
 	Data       kvMap  `json:"data" yaml:"data"`
+	StringData kvMap  `json:"stringData,omitempty" yaml:"stringData,omitempty"`
 	Type       string `json:"type,omitempty" yaml:"type,omitempty"`
 }
 
+// options are the command line options of the plugin
+type options struct {
+	asOf     string
+	explain  io.Writer
+	lockfile string
+	warnings io.Writer
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		_, _ = fmt.Fprintln(os.Stderr, "usage: KGCPSecret FILE")
+	flags := flag.NewFlagSet("KGCPSecret", flag.ContinueOnError)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(os.Stderr, "usage: KGCPSecret [--as-of TIMESTAMP] [--explain] [--lockfile FILE] [--error-format text|json] FILE")
+		flags.PrintDefaults()
+	}
+	asOf := flags.String("as-of", os.Getenv("KGCPSECRET_AS_OF"),
+		"render the secret versions as they were at this RFC 3339 timestamp (overrides 'asOf')")
+	explain := flags.Bool("explain", os.Getenv("KGCPSECRET_EXPLAIN") == "true",
+		"print the secret and version used for every key to stderr")
+	lockfile := flags.String("lockfile", os.Getenv("KGCPSECRET_LOCKFILE"),
+		"read the secret versions locked in this file and record the versions read in it")
+	errorFormat := flags.String("error-format", os.Getenv("KGCPSECRET_ERROR_FORMAT"),
+		"format of the error report on stderr, 'text' (default) or 'json'")
+	if err := flags.Parse(os.Args[1:]); err != nil || flags.NArg() != 1 ||
+		(*errorFormat != "" && *errorFormat != "text" && *errorFormat != "json") {
+		flags.Usage()
 		os.Exit(1)
 	}
 
-	output, err := processEncryptedGCPSecret(os.Args[1])
+	opts := options{asOf: *asOf, lockfile: *lockfile, warnings: os.Stderr}
+	if *explain {
+		opts.explain = os.Stderr
+	}
+	output, err := processEncryptedGCPSecret(flags.Arg(0), opts)
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		writeErrorReport(os.Stderr, err, *errorFormat)
 		os.Exit(2)
 	}
 	fmt.Print(output)
 }
 
-func processEncryptedGCPSecret(fn string) (string, error) {
+func processEncryptedGCPSecret(fn string, opts options) (string, error) {
 	input, err := readInput(fn)
 	if err != nil {
 		return "", err
 	}
+	if opts.asOf != "" {
+		input.AsOf = opts.asOf
+	}
+	if opts.explain != nil {
+		input.explanation = newExplanation()
+	}
+	if opts.lockfile != "" {
+		if input.lock, err = readLockfile(opts.lockfile); err != nil {
+			return "", err
+		}
+	}
+	input.warnings = opts.warnings
+	input.warnDeprecations()
+	input.warnKeyOptions()
+	existedAt, err := input.asOfTime()
+	if err != nil {
+		return "", err
+	}
+	input.caller, err = newGCPCaller(&input)
+	if err != nil {
+		return "", err
+	}
+	listSecrets := func(projectID string) ([]string, error) {
+		return listGCPSecrets(projectID, "", existedAt, input.caller)
+	}
+	input.listLabeledSecrets = func(projectID string, filter string) ([]string, error) {
+		return listGCPSecrets(projectID, filter, existedAt, input.caller)
+	}
+
 	ctx := context.Background()
 	client, err := secretmanager.NewClient(ctx)
 	if err != nil {
@@ -105,14 +219,24 @@ func processEncryptedGCPSecret(fn string) (string, error) {
 	}
 	defer client.Close()
 
-	secret, err := GetSecrets(ctx, client, &input, listGCPSecrets, getGCPSecretValue)
+	getSecretValue := getGCPSecretValue
+	if !input.readsPayloads() {
+		getSecretValue = getGCPSecretReference
+	}
+	resource, err := renderOutput(ctx, client, &input, listSecrets, getSecretValue)
 	if err != nil {
 		return "", err
 	}
-	output, err := yaml.Marshal(secret)
+	if opts.explain != nil {
+		input.explanation.write(opts.explain)
+	}
+	output, err := yaml.Marshal(resource)
 	if err != nil {
 		return "", err
 	}
+	if err := input.lock.write(opts.lockfile); err != nil {
+		return "", err
+	}
 	return string(output), nil
 }
 
@@ -122,13 +246,7 @@ func readInput(fn string) (KGCPSecret, error) {
 		return KGCPSecret{}, err
 	}
 
-	input := KGCPSecret{
-		TypeMeta: TypeMeta{},
-		GCPObjectMeta: GCPObjectMeta{
-			Annotations: make(kvMap),
-		},
-	}
-	err = yaml.Unmarshal(content, &input)
+	input, err := parseInput(content)
 	if err != nil {
 		return KGCPSecret{}, err
 	}
@@ -136,6 +254,46 @@ func readInput(fn string) (KGCPSecret, error) {
 	if input.Name == "" {
 		return KGCPSecret{}, errors.New("input must contain metadata.name value")
 	}
+	if err := input.validateOutput(); err != nil {
+		return KGCPSecret{}, err
+	}
+	if _, err := input.versionCutoff(); err != nil {
+		return KGCPSecret{}, err
+	}
+	if _, err := newGCPCaller(&input); err != nil {
+		return KGCPSecret{}, err
+	}
+	if err := input.validateRequireMatch(); err != nil {
+		return KGCPSecret{}, err
+	}
+	if err := input.validateBuilders(); err != nil {
+		return KGCPSecret{}, err
+	}
+	for i, selector := range input.KeySelectors {
+		if err := selector.validate(); err != nil {
+			return KGCPSecret{}, errors.Wrapf(err, "invalid keySelectors entry %d", i+1)
+		}
+	}
+	if err := input.validateKeyOptions(); err != nil {
+		return KGCPSecret{}, err
+	}
+	for key, keyOptions := range input.KeyOptions {
+		if _, err := input.forKey(key).versionCutoff(); err != nil {
+			return KGCPSecret{}, errors.Wrapf(err, "invalid keyOptions for key '%s'", key)
+		}
+		if err := input.forKey(key).validateRequireMatch(); err != nil {
+			return KGCPSecret{}, errors.Wrapf(err, "invalid keyOptions for key '%s'", key)
+		}
+		if err := keyOptions.validateDefaults(input.withBuilderKeys(), key); err != nil {
+			return KGCPSecret{}, errors.Wrapf(err, "invalid keyOptions for key '%s'", key)
+		}
+		if err := keyOptions.validateTransforms(input.output()); err != nil {
+			return KGCPSecret{}, errors.Wrapf(err, "invalid keyOptions for key '%s'", key)
+		}
+		if err := keyOptions.Validate.validate(); err != nil {
+			return KGCPSecret{}, errors.Wrapf(err, "invalid keyOptions for key '%s'", key)
+		}
+	}
 
 	return input, nil
 }
@@ -147,12 +305,50 @@ func readInput(fn string) (KGCPSecret, error) {
 // getGCPSecretValue: get the value for a specific secret in Google Secret Manager
 func GetSecrets(ctx context.Context, client *secretmanager.Client,
 	plugin *KGCPSecret, listGCPSecrets secretsGetter, getGCPSecretValue secretValueGetter) (K8SSecret, error) {
-	data, err := createGCPSecretValuesGetter(plugin, listGCPSecrets)(ctx, client, plugin, getGCPSecretValue)
+	data, secretType, err := getSecretData(ctx, client, plugin, listGCPSecrets, getGCPSecretValue)
 
 	if err != nil {
 		return K8SSecret{}, err
 	}
 
+	secret := K8SSecret{
+		TypeMeta: TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: generatedObjectMeta(plugin),
+		Data:       data,
+		Type:       secretType,
+	}
+	if plugin.StringData {
+		// binary values stay in data
+		text, binary, err := splitTextValues(data)
+		if err != nil {
+			return K8SSecret{}, err
+		}
+		secret.StringData = text
+		secret.Data = make(kvMap)
+		for key, value := range binary {
+			secret.Data[key] = value
+		}
+	}
+	if err := validateK8SSecret(secret); err != nil {
+		return K8SSecret{}, err
+	}
+	return secret, nil
+}
+
+// warn tells about a problem which does not stop the generation
+func (p *KGCPSecret) warn(message string) {
+	if p.warnings != nil {
+		_, _ = fmt.Fprintf(p.warnings, "Warning: %s\n", message)
+	}
+}
+
+// generatedObjectMeta returns the metadata of the resource generated for a KGCPSecret,
+// including the annotations for Kustomize
+func generatedObjectMeta(plugin *KGCPSecret) ObjectMeta {
+	objectMeta := pluginObjectMeta(plugin)
 	annotations := make(kvMap)
 	for k, v := range plugin.Annotations {
 		annotations[k] = v
@@ -164,21 +360,35 @@ func GetSecrets(ctx context.Context, client *secretmanager.Client,
 		annotations["kustomize.config.k8s.io/behavior"] = plugin.Behavior
 	}
 
-	secret := K8SSecret{
-		TypeMeta: TypeMeta{
-			APIVersion: "v1",
-			Kind:       "Secret",
-		},
-		ObjectMeta: ObjectMeta{
-			Name:        plugin.Name,
-			Namespace:   plugin.Namespace,
-			Labels:      plugin.Labels,
-			Annotations: annotations,
-		},
-		Data: data,
-		Type: plugin.Type,
+	objectMeta.Annotations = annotations
+	return objectMeta
+}
+
+// forKey returns the KGCPSecret with the KeyOptions of the given key applied
+func (p *KGCPSecret) forKey(key string) *KGCPSecret {
+	options, ok := p.KeyOptions[key]
+	if !ok {
+		return p
 	}
-	return secret, nil
+	keyPlugin := *p
+	if options.MinVersionAge != "" {
+		keyPlugin.MinVersionAge = options.MinVersionAge
+	}
+	if options.RequireMatch != "" {
+		keyPlugin.RequireMatch = options.RequireMatch
+	}
+	return &keyPlugin
+}
+
+// lookupStopper is implemented by errors which show that a secret exists, but cannot be used.
+// They stop the lookup of less specific secrets.
+type lookupStopper interface {
+	stopsLookup()
+}
+
+func stopsLookup(err error) bool {
+	var stopper lookupStopper
+	return errors.As(err, &stopper)
 }
 
 type secretValueGetter func(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error)
@@ -186,18 +396,57 @@ type secretValuesGetter func(ctx context.Context, client *secretmanager.Client,
 type secretsGetter func(string) ([]string, error)
 
 func createGCPSecretValuesGetter(plugin *KGCPSecret, listGCPSecrets secretsGetter) secretValuesGetter {
-	allSecretKeys, _ := listGCPSecrets(plugin.GCPProjectID)
+	allSecretKeys, listErr := listGCPSecrets(plugin.GCPProjectID)
 
 	return func(ctx context.Context, client *secretmanager.Client,
 		plugin *KGCPSecret, getSecretValues secretValueGetter) (secrets kvMap, err error) {
+		if listErr != nil {
+			return nil, fmt.Errorf("error listing secrets in Google project '%s'. %s", plugin.GCPProjectID, listErr)
+		}
+		selectedKeys, err := selectKeys(plugin, allSecretKeys)
+		if err != nil {
+			return nil, fmt.Errorf("error selecting keys in Google project '%s'. %s", plugin.GCPProjectID, err)
+		}
+		keys := append(append([]string{}, plugin.Keys...), selectedKeys...)
+		plugin.explanation.recordKeys(keys, selectedKeys)
+
 		secrets = make(map[string]string)
-		for _, key := range plugin.Keys {
-			value, err := getBestFittingSecretValue(ctx, client, plugin, allSecretKeys, key, getSecretValues)
+		var keyErrors secretErrors
+		for _, key := range keys {
+			value, err := getBestFittingSecretValue(ctx, client, plugin.forKey(key), allSecretKeys, key, getSecretValues)
+			if err == nil {
+				value, err = transformValue(plugin, key, value)
+			}
 			if err != nil {
-				return nil, err
+				keyErrors = append(keyErrors, err.(*keyError))
+				continue
 			}
 			secrets[key] = value
 		}
+		keyErrors = applyKeyDefaults(plugin, secrets, keyErrors)
+		for _, key := range keys {
+			value, ok := secrets[key]
+			if !ok {
+				continue
+			}
+			inEnvironment := func(environment string) (string, error) {
+				other := *plugin.forKey(key)
+				other.Environment = environment
+				// the lookup in the other environment is not part of the explanation
+				other.explanation = nil
+				value, err := getBestFittingSecretValue(ctx, client, &other, allSecretKeys, key, getSecretValues)
+				if err != nil {
+					return "", err
+				}
+				return transformValue(plugin, key, value)
+			}
+			if err := validateValue(plugin, key, value, inEnvironment); err != nil {
+				keyErrors = append(keyErrors, err)
+			}
+		}
+		if len(keyErrors) > 0 {
+			return nil, keyErrors
+		}
 
 		return
 	}
@@ -205,45 +454,55 @@ func createGCPSecretValuesGetter(plugin *KGCPSecret, listGCPSecrets secretsGette
 
 func getBestFittingSecretValue(ctx context.Context, client *secretmanager.Client,
 	plugin *KGCPSecret, allKeys []string, key string, getSecretValue secretValueGetter) (string, error) {
-	var err = errors.New(fmt.Sprintf("key '%s' was not found", key))
-	value := ""
-	environment := plugin.Stage
-	if plugin.Environment != "" {
-		environment = plugin.Environment
-	}
-	tag := plugin.Dc
-	if plugin.Tag != "" {
-		tag = plugin.Tag
-	}
-	prefixes := []string{
-		plugin.Namespace + "_" + plugin.Name + "_",
-		plugin.Name + "_",
-		plugin.Namespace + "_",
-		"",
-	}
-	postfixes := []string{
-		"_" + environment + "_" + tag,
-		"_" + environment,
-		"_" + tag,
-		"",
-	}
-	for _, prefix := range prefixes {
-		for _, postfix := range postfixes {
-			lookupKey := prefix + key + postfix
-			for _, k := range allKeys {
-				if k == lookupKey {
-					value, err = getSecretValue(ctx, client, plugin, lookupKey)
-					if err == nil && value != "" {
-						return value, nil
+	keyErr := &keyError{
+		Key:      key,
+		Project:  plugin.GCPProjectID,
+		Category: categoryNotFound,
+		err:      errors.New(fmt.Sprintf("key '%s' was not found", key)),
+	}
+	forbidden := ""
+	for _, candidate := range lookupCandidates(plugin, key) {
+		lookupKey := candidate.name
+		allowed := plugin.allowsMatch(candidate)
+		if allowed {
+			keyErr.Candidates = append(keyErr.Candidates, lookupKey)
+		}
+		for _, k := range allKeys {
+			if k == lookupKey {
+				if !allowed {
+					if forbidden == "" {
+						forbidden = lookupKey
 					}
+					continue
+				}
+				value, err := getSecretValue(ctx, client, plugin, lookupKey)
+				if err == nil && value != "" {
+					plugin.explanation.recordKey(key, lookupKey)
+					return value, nil
+				}
+				if err == nil {
+					err = fmt.Errorf("secret '%s' is empty", lookupKey)
+				}
+				keyErr.err = err
+				keyErr.Category = errorCategory(err)
+				if stopsLookup(err) {
+					// the secret exists, so less specific secrets must not be used instead
+					return "", keyErr
 				}
 			}
 		}
 	}
-	return "", fmt.Errorf("error getting '%s' secret in Google project '%s'. %s", key, plugin.GCPProjectID, err)
+	if forbidden != "" && keyErr.Category == categoryNotFound {
+		keyErr.Category = categoryPolicy
+		keyErr.err = fmt.Errorf("only secret '%s' exists, which is less specific than requireMatch '%s' allows",
+			forbidden, plugin.RequireMatch)
+	}
+	return "", keyErr
 }
 
-func listGCPSecrets(projectID string) ([]string, error) {
+// listGCPSecrets lists the names of the secrets in the project which match the filter, an empty filter
+// lists all secrets. If existedAt is set, secrets created after that point in time are left out.
+func listGCPSecrets(projectID string, filter string, existedAt time.Time, caller *gcpCaller) ([]string, error) {
 	secrets := []string{}
 
 	ctx := context.Background()
@@ -255,37 +514,110 @@ func listGCPSecrets(projectID string) ([]string, error) {
 
 	req := &secretmanagerpb.ListSecretsRequest{
 		Parent: "projects/" + projectID,
+		Filter: filter,
 	}
 
-	it := client.ListSecrets(ctx, req)
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
+	err = caller.call(ctx, "list secrets of project "+projectID, func(ctx context.Context) error {
+		secrets = secrets[:0]
+		it := client.ListSecrets(ctx, req, noClientRetry)
+		for {
+			resp, err := it.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
 
-		if err != nil {
-			return []string{}, fmt.Errorf("failed to list secret versions: %v", err)
-		}
+			if !existedAt.IsZero() && resp.GetCreateTime().AsTime().After(existedAt) {
+				continue
+			}
 
-		name := strings.Split(resp.Name, "/")[3]
-		secrets = append(secrets, name)
+			name := strings.Split(resp.Name, "/")[3]
+			secrets = append(secrets, name)
+		}
+	})
+	if err != nil {
+		return []string{}, fmt.Errorf("failed to list secrets: %v", err)
 	}
 
 	return secrets, nil
 }
 
 func getGCPSecretValue(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
-	sanitizedKeyName := sanitizeKeyName(key)
-	name := fmt.Sprintf("projects/%s/secrets/%s/versions/latest", plugin.GCPProjectID, sanitizedKeyName)
-	request := &secretmanagerpb.AccessSecretVersionRequest{Name: name}
-	secret, err := client.AccessSecretVersion(ctx, request)
+	secret, err := readGCPSecretVersion(ctx, client, plugin, key, accessGCPSecretVersion)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(secret.GetPayload().GetData()), nil
+}
+
+// getGCPSecretReference resolves the version of a secret like getGCPSecretValue, but only reads its metadata.
+// The value is the name of the version, for the outputs which reference the secrets instead of writing their values.
+func getGCPSecretReference(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+	secret, err := readGCPSecretVersion(ctx, client, plugin, key, getGCPSecretVersionMetadata)
 	if err != nil {
-		return "", errors.Wrapf(err, "trouble retrieving secret: %s", name)
+		return "", err
 	}
-	value := base64.StdEncoding.EncodeToString(secret.GetPayload().GetData())
+	return secret.GetName(), nil
+}
 
-	return value, nil
+// secretVersionReader reads a secret version by its name, with or without the payload
+type secretVersionReader func(ctx context.Context, client *secretmanager.Client, caller *gcpCaller,
+	name string) (*secretmanagerpb.AccessSecretVersionResponse, error)
+
+// readGCPSecretVersion selects the version of a secret for asOf, minVersionAge and the lockfile and reads it
+func readGCPSecretVersion(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string,
+	read secretVersionReader) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	parent := fmt.Sprintf("projects/%s/secrets/%s", plugin.GCPProjectID, sanitizeKeyName(key))
+	cutoff, err := plugin.versionCutoff()
+	if err != nil {
+		return nil, err
+	}
+	asOf, err := plugin.asOfTime()
+	if err != nil {
+		return nil, err
+	}
+	caller := plugin.caller
+	if caller == nil {
+		if caller, err = newGCPCaller(plugin); err != nil {
+			return nil, err
+		}
+	}
+	version := "latest"
+	// an explicit asOf or minVersionAge takes precedence over the lockfile
+	lockedVersion, locked := plugin.lock.lockedVersion(parent)
+	switch {
+	case !cutoff.IsZero():
+		version, err = findGCPSecretVersion(ctx, client, caller, plugin, parent, cutoff, !asOf.IsZero())
+		if err != nil {
+			return nil, err
+		}
+	case locked:
+		version = lockedVersion
+	}
+	name := parent + "/versions/" + version
+	secret, err := read(ctx, client, caller, name)
+	if err != nil && locked && version == lockedVersion {
+		return nil, lockedVersionError(parent, version, err)
+	}
+	if status.Code(err) == codes.FailedPrecondition && version == "latest" {
+		// the latest version is disabled or destroyed
+		version, err = findEnabledGCPSecretVersion(ctx, client, caller, plugin, parent)
+		if err != nil {
+			return nil, err
+		}
+		name = parent + "/versions/" + version
+		secret, err = read(ctx, client, caller, name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "trouble retrieving secret: %s", name)
+	}
+	if err := plugin.lock.record(parent, secret); err != nil {
+		return nil, err
+	}
+	plugin.explanation.recordVersion(key, secret)
+	return secret, nil
 }
 
 func sanitizeKeyName(name string) string {
@@ -0,0 +1,142 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+// credentials as returned by Google Secret Manager, base64 encoded
+var credential_values = encodeTestValues(map[string]string{
+	"ADMIN_USER":     "admin",
+	"ADMIN_PASSWORD": "correct horse battery staple",
+	"DEPLOY_USER":    "deploy",
+	"DEPLOY_PASS":    "s3cr3t",
+})
+
+func getCredentialTestValue(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+	if value, ok := credential_values[key]; ok {
+		return value, nil
+	}
+	return "", errors.New("no value found for key")
+}
+
+func getCredentialTestKeys(project_id string) ([]string, error) {
+	keys := []string{}
+	for k := range credential_values {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func decodedData(secret K8SSecret, key string) string {
+	value, err := base64.StdEncoding.DecodeString(secret.Data[key])
+	Expect(err).ToNot(HaveOccurred())
+	return string(value)
+}
+
+var _ = Describe("when creating a kubernetes.io/basic-auth secret", func() {
+	It("should map the credentials to username and password", func() {
+		encryptedSecret := createEncryptedGCPSecret("admin-credentials", "")
+		encryptedSecret.Type = "kubernetes.io/basic-auth"
+		encryptedSecret.Keys = nil
+		encryptedSecret.BasicAuth = BasicAuthOptions{UsernameKey: "ADMIN_USER", PasswordKey: "ADMIN_PASSWORD"}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getCredentialTestKeys, getCredentialTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(encodeTestValues(map[string]string{
+			"username": "admin",
+			"password": "correct horse battery staple",
+		})))
+	})
+
+	It("should fail without username and password", func() {
+		encryptedSecret := createEncryptedGCPSecret("admin-credentials", "ADMIN_USER")
+		encryptedSecret.Type = "kubernetes.io/basic-auth"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getCredentialTestKeys, getCredentialTestValue)
+		Expect(err).To(MatchError("a secret of type kubernetes.io/basic-auth needs the key username or password"))
+	})
+})
+
+var _ = Describe("when creating a secret with an htpasswd file", func() {
+	newHtpasswdPlugin := func() KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("ingress-auth", "")
+		encryptedSecret.Keys = nil
+		encryptedSecret.Htpasswd = HtpasswdOptions{
+			Users: []HtpasswdUser{
+				{UsernameKey: "ADMIN_USER", PasswordKey: "ADMIN_PASSWORD"},
+				{UsernameKey: "DEPLOY_USER", PasswordKey: "DEPLOY_PASS"},
+			},
+			Cost: 4,
+		}
+		return encryptedSecret
+	}
+
+	It("should write a bcrypt hash for every user to auth", func() {
+		encryptedSecret := newHtpasswdPlugin()
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getCredentialTestKeys, getCredentialTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(HaveLen(1))
+		lines := strings.Split(strings.TrimSuffix(decodedData(actual, "auth"), "\n"), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(HavePrefix("admin:$2y$04$"))
+		Expect(bcrypt.CompareHashAndPassword([]byte(strings.TrimPrefix(lines[0], "admin:")), []byte("correct horse battery staple"))).To(Succeed())
+		Expect(lines[1]).To(HavePrefix("deploy:$2y$04$"))
+		Expect(bcrypt.CompareHashAndPassword([]byte(strings.TrimPrefix(lines[1], "deploy:")), []byte("s3cr3t"))).To(Succeed())
+	})
+
+	It("should use random salts by default", func() {
+		first, second := newHtpasswdPlugin(), newHtpasswdPlugin()
+
+		firstSecret, err := GetSecrets(ctx, nil, &first, getCredentialTestKeys, getCredentialTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		secondSecret, err := GetSecrets(ctx, nil, &second, getCredentialTestKeys, getCredentialTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(firstSecret.Data["auth"]).ToNot(Equal(secondSecret.Data["auth"]))
+	})
+
+	It("should create the same hashes with deterministic salts", func() {
+		first, second := newHtpasswdPlugin(), newHtpasswdPlugin()
+		first.Htpasswd.DeterministicSalt = true
+		second.Htpasswd.DeterministicSalt = true
+		second.Htpasswd.Key = "users"
+
+		firstSecret, err := GetSecrets(ctx, nil, &first, getCredentialTestKeys, getCredentialTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		secondSecret, err := GetSecrets(ctx, nil, &second, getCredentialTestKeys, getCredentialTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(secondSecret.Data["users"]).To(Equal(firstSecret.Data["auth"]))
+		lines := strings.Split(decodedData(firstSecret, "auth"), "\n")
+		Expect(bcrypt.CompareHashAndPassword([]byte(strings.TrimPrefix(lines[0], "admin:")), []byte("correct horse battery staple"))).To(Succeed())
+	})
+})
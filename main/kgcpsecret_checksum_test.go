@@ -0,0 +1,165 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func createPayload(data string, checksum int64) *secretmanagerpb.SecretPayload {
+	return &secretmanagerpb.SecretPayload{
+		Data:       []byte(data),
+		DataCrc32C: &checksum,
+	}
+}
+
+var _ = Describe("when verifying the checksum of a secret payload", func() {
+	name := "projects/cf-2tier-uhd-test-d7/secrets/db-password/versions/3"
+
+	It("should accept a payload matching its checksum", func() {
+		Expect(VerifyPayloadChecksum(name, createPayload("123456789", 3808858755))).To(Succeed())
+	})
+
+	It("should accept a payload without checksum", func() {
+		Expect(VerifyPayloadChecksum(name, &secretmanagerpb.SecretPayload{Data: []byte("secret1-42")})).To(Succeed())
+	})
+
+	It("should report a corrupted payload", func() {
+		expected := "payload of secret projects/cf-2tier-uhd-test-d7/secrets/db-password/versions/3 is corrupted: " +
+			"expected CRC32C checksum 42, got 3808858755"
+		err := VerifyPayloadChecksum(name, createPayload("123456789", 42))
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(expected))
+	})
+})
+
+var _ = Describe("when explaining the resolution of the keys", func() {
+
+	It("should list the secret used for every key", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "VALUE3")
+		encryptedSecret.Namespace = "my-namespace"
+		encryptedSecret.Keys = append(encryptedSecret.Keys, "VALUE1")
+		explain := EnableExplanation(&encryptedSecret)
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getPrefixTestKeys, getPrefixTestValue)
+		Expect(err).ToNot(HaveOccurred())
+
+		output := &bytes.Buffer{}
+		explain(output)
+		Expect(output.String()).To(Equal("VALUE3: secret my-namespace_my-secret_VALUE3\nVALUE1: secret my-secret_VALUE1\n"))
+	})
+})
+
+var _ = Describe("when locking the versions read", func() {
+	parent := "projects/cf-2tier-uhd-test-d7/secrets/db-password"
+	var dir, fn string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "lockfile")
+		Expect(err).ToNot(HaveOccurred())
+		fn = filepath.Join(dir, "secrets.lock")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should record the version and checksum of every secret", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "db-password")
+		Expect(UseLockfile(&encryptedSecret, fn)).To(Succeed())
+		_, ok := LockedVersion(&encryptedSecret, parent)
+		Expect(ok).To(BeFalse())
+
+		Expect(LockSecretVersion(&encryptedSecret, parent, parent+"/versions/3", "123456789")).To(Succeed())
+		Expect(WriteLockfile(&encryptedSecret, fn)).To(Succeed())
+		content, err := ioutil.ReadFile(fn)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal("secrets:\n  projects/cf-2tier-uhd-test-d7/secrets/db-password:\n" +
+			"    version: \"3\"\n    crc32c: 3808858755\n"))
+
+		lockedSecret := createEncryptedGCPSecret("other-secret", "db-password")
+		Expect(UseLockfile(&lockedSecret, fn)).To(Succeed())
+		version, ok := LockedVersion(&lockedSecret, parent)
+		Expect(ok).To(BeTrue())
+		Expect(version).To(Equal("3"))
+	})
+
+	It("should report a payload which differs from the locked one", func() {
+		Expect(ioutil.WriteFile(fn, []byte("secrets:\n  "+parent+":\n    version: \"3\"\n    crc32c: 42\n"), 0644)).To(Succeed())
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "db-password")
+		Expect(UseLockfile(&encryptedSecret, fn)).To(Succeed())
+
+		err := LockSecretVersion(&encryptedSecret, parent, parent+"/versions/3", "123456789")
+		Expect(err).To(MatchError("payload of secret projects/cf-2tier-uhd-test-d7/secrets/db-password/versions/3 is corrupted: " +
+			"expected CRC32C checksum 42, got 3808858755"))
+	})
+
+	It("should record the versions referenced without a checksum and keep the checksum read before", func() {
+		Expect(ioutil.WriteFile(fn, []byte("secrets:\n  "+parent+":\n    version: \"3\"\n    crc32c: 42\n"), 0644)).To(Succeed())
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "db-password")
+		Expect(UseLockfile(&encryptedSecret, fn)).To(Succeed())
+
+		Expect(LockSecretReference(&encryptedSecret, parent, parent+"/versions/3")).To(Succeed())
+		err := LockSecretVersion(&encryptedSecret, parent, parent+"/versions/3", "123456789")
+		Expect(err).To(MatchError(ContainSubstring("expected CRC32C checksum 42, got 3808858755")))
+
+		Expect(LockSecretReference(&encryptedSecret, parent, parent+"/versions/4")).To(Succeed())
+		Expect(WriteLockfile(&encryptedSecret, fn)).To(Succeed())
+		content, err := ioutil.ReadFile(fn)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal("secrets:\n  projects/cf-2tier-uhd-test-d7/secrets/db-password:\n" +
+			"    version: \"4\"\n"))
+		Expect(LockSecretVersion(&encryptedSecret, parent, parent+"/versions/4", "123456789")).To(Succeed())
+	})
+
+	It("should stop the lookup when a locked version cannot be read anymore", func() {
+		err := LockedVersionError(parent, "3", status.Error(codes.FailedPrecondition, "version is disabled"))
+		Expect(err).To(MatchError("secret projects/cf-2tier-uhd-test-d7/secrets/db-password has version 3 locked in the " +
+			"lockfile, but it is disabled or destroyed"))
+		Expect(StopsLookup(err)).To(BeTrue())
+
+		err = LockedVersionError(parent, "3", status.Error(codes.NotFound, "version not found"))
+		Expect(err).To(MatchError(ContainSubstring("has version 3 locked in the lockfile, but it does not exist anymore")))
+		Expect(StopsLookup(err)).To(BeTrue())
+
+		err = LockedVersionError(parent, "3", status.Error(codes.PermissionDenied, "denied"))
+		Expect(StopsLookup(err)).To(BeFalse())
+	})
+
+	It("should reject an invalid lockfile", func() {
+		Expect(ioutil.WriteFile(fn, []byte("secret: {}\n"), 0644)).To(Succeed())
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "db-password")
+
+		Expect(UseLockfile(&encryptedSecret, fn)).To(MatchError(HavePrefix("invalid lockfile " + fn)))
+	})
+})
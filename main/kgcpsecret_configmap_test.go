@@ -0,0 +1,89 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+// config values as returned by Google Secret Manager, base64 encoded
+var config_values = map[string]string{
+	"API_ENDPOINT": "aHR0cHM6Ly9hcGkubWV0cm8uZGlnaXRhbA==",
+	"FEATURES":     "c2VhcmNoLGNoZWNrb3V0",
+	"LOGO":         "iVBORw0KGgo=",
+}
+
+func getConfigTestValue(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+	if value, ok := config_values[key]; ok {
+		return value, nil
+	}
+	return "", errors.New("no value found for key")
+}
+
+func getConfigTestKeys(project_id string) ([]string, error) {
+	keys := []string{}
+	for k := range config_values {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+var _ = Describe("when creating a Kubernetes config map from a KGCPConfigMap", func() {
+	encryptedSecret := createEncryptedGCPSecret("my-config", "API_ENDPOINT")
+	encryptedSecret.Kind = "KGCPConfigMap"
+	encryptedSecret.DisableNameSuffixHash = false
+	encryptedSecret.Behavior = "merge"
+	encryptedSecret.Keys = []string{"API_ENDPOINT", "FEATURES", "LOGO"}
+
+	It("should create a config map with plain text and binary data", func() {
+		expected := K8SConfigMap{
+			TypeMeta: TypeMeta{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+			},
+			ObjectMeta: ObjectMeta{
+				Name:   "my-config",
+				Labels: map[string]string{},
+				Annotations: map[string]string{
+					"kustomize.config.k8s.io/needs-hash": "true",
+					"kustomize.config.k8s.io/behavior":   "merge",
+				},
+			},
+			Data: map[string]string{
+				"API_ENDPOINT": "https://api.metro.digital",
+				"FEATURES":     "search,checkout",
+			},
+			BinaryData: map[string]string{
+				"LOGO": "iVBORw0KGgo=",
+			},
+		}
+
+		actual, err := GetConfigMap(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expected))
+	})
+})
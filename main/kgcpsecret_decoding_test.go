@@ -0,0 +1,154 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"bytes"
+	"path/filepath"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("when decoding a KGCPSecret", func() {
+	const header = "apiVersion: metro.digital/v1\nkind: KGCPSecret\nmetadata:\n  name: shop\n"
+
+	It("should decode a valid input", func() {
+		input, err := ParseInput([]byte(header + "gcpProjectID: cf-2tier-uhd-test-d7\nkeys:\n- API_ENDPOINT\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(input.GCPProjectID).To(Equal("cf-2tier-uhd-test-d7"))
+		Expect(input.Keys).To(Equal([]string{"API_ENDPOINT"}))
+	})
+
+	It("should report unknown fields with their line and a suggestion", func() {
+		_, err := ParseInput([]byte(header + "gcpProjectId: cf-2tier-uhd-test-d7\nkey:\n- API_ENDPOINT\n"))
+		Expect(err).To(MatchError("invalid KGCPSecret:\n" +
+			"- line 5: unknown field 'gcpProjectId', did you mean 'gcpProjectID'?\n" +
+			"- line 6: unknown field 'key', did you mean 'keys'?"))
+	})
+
+	It("should suggest fields of nested settings", func() {
+		_, err := ParseInput([]byte(header + "keys:\n- API_ENDPOINT\nkeyOptions:\n  API_ENDPOINT:\n    optinal: true\n"))
+		Expect(err).To(MatchError(ContainSubstring("line 9: unknown field 'optinal', did you mean 'optional'?")))
+	})
+
+	It("should not suggest unrelated fields", func() {
+		_, err := ParseInput([]byte(header + "keys:\n- API_ENDPOINT\nsomethingElse: true\n"))
+		Expect(err).To(MatchError("invalid KGCPSecret:\n- line 7: unknown field 'somethingElse'"))
+	})
+
+	It("should report unknown metadata fields with their line", func() {
+		_, err := ParseInput([]byte(header + "  namepsace: shop\n  futureField: value\nkeys:\n- API_ENDPOINT\n"))
+		Expect(err).To(MatchError("invalid KGCPSecret:\n" +
+			"- line 5: unknown field 'namepsace', did you mean 'namespace'?\n" +
+			"- line 6: unknown field 'futureField'"))
+	})
+
+	It("should reject metadata fields set by the API server", func() {
+		_, err := ParseInput([]byte(header + "  uid: 0b9f5c1e\n  deletionGracePeriodSeconds: 30\nkeys:\n- API_ENDPOINT\n"))
+		Expect(err).To(MatchError("invalid KGCPSecret:\n" +
+			"- line 5: field 'metadata.uid' is set by the Kubernetes API server and cannot be set\n" +
+			"- line 6: field 'metadata.deletionGracePeriodSeconds' is set by the Kubernetes API server and cannot be set"))
+	})
+
+	It("should decode the metadata fields a client may set", func() {
+		input, err := ParseInput([]byte(header + "  generateName: shop-\n  finalizers:\n  - metro.digital/cleanup\n" +
+			"  ownerReferences:\n  - apiVersion: apps/v1\n    kind: Deployment\n    name: shop\n    uid: 0b9f5c1e\n" +
+			"keys:\n- API_ENDPOINT\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(input.GenerateName).To(Equal("shop-"))
+		Expect(input.Finalizers).To(Equal([]string{"metro.digital/cleanup"}))
+		Expect(input.OwnerReferences).To(Equal([]OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "shop", UID: "0b9f5c1e"}}))
+
+		_, err = ParseInput([]byte(header + "  ownerReferences:\n  - nmae: shop\nkeys:\n- API_ENDPOINT\n"))
+		Expect(err).To(MatchError("invalid KGCPSecret:\n- line 6: unknown field 'nmae', did you mean 'name'?"))
+	})
+
+	It("should validate apiVersion and kind", func() {
+		_, err := ParseInput([]byte("apiVersion: metro.digital/v2\nkind: KGCPSecret\nmetadata:\n  name: shop\nkeys:\n- API_ENDPOINT\n"))
+		Expect(err).To(MatchError("apiVersion must be 'metro.digital/v1', got 'metro.digital/v2'"))
+
+		_, err = ParseInput([]byte("apiVersion: metro.digital/v1\nkind: Secret\nmetadata:\n  name: shop\nkeys:\n- API_ENDPOINT\n"))
+		Expect(err).To(MatchError("kind must be 'KGCPSecret' or 'KGCPConfigMap', got 'Secret'"))
+	})
+
+	It("should reject inputs without keys", func() {
+		_, err := ParseInput([]byte(header + "keys: []\n"))
+		Expect(err).To(MatchError("input must contain keys, keySelectors or a builder reading keys"))
+
+		_, err = ParseInput([]byte(header + "keys:\n- API_ENDPOINT\n- \"\"\n"))
+		Expect(err).To(MatchError("keys entry 2 is empty"))
+
+		_, err = ParseInput([]byte(header + "keySelectors:\n- glob: API_*\n"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should read every example", func() {
+		examples, err := filepath.Glob("../example/*.yaml")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(examples).ToNot(BeEmpty())
+		for _, example := range examples {
+			if filepath.Base(example) == "kustomization.yaml" {
+				continue
+			}
+			_, err := ReadInput(example)
+			Expect(err).ToNot(HaveOccurred(), example)
+		}
+	})
+
+	It("should report keyOptions of unknown keys with a suggestion", func() {
+		input, err := ParseInput([]byte(header + "keys:\n- API_ENDPOINT\n- db-password\nkeyOptions:\n" +
+			"  API_ENDPIONT:\n    optional: true\n  something-else:\n    optional: true\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ValidateKeyOptions(&input)).To(MatchError("invalid keyOptions:\n" +
+			"- key 'API_ENDPIONT' is not one of the keys, did you mean 'API_ENDPOINT'?\n" +
+			"- key 'something-else' is not one of the keys"))
+	})
+
+	It("should accept keyOptions of keys read by a builder", func() {
+		input, err := ParseInput([]byte(header + "type: kubernetes.io/basic-auth\nbasicAuth:\n" +
+			"  usernameKey: SHOP_USER\n  passwordKey: SHOP_PASSWORD\nkeyOptions:\n  SHOP_USER:\n    default: shop\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ValidateKeyOptions(&input)).To(Succeed())
+	})
+
+	It("should only warn about keyOptions of unknown keys with keySelectors", func() {
+		input, err := ParseInput([]byte(header + "keySelectors:\n- glob: 'shop-*'\nkeyOptions:\n" +
+			"  shop-url:\n    optional: true\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ValidateKeyOptions(&input)).To(Succeed())
+		warnings := &bytes.Buffer{}
+		SetWarnings(&input, warnings)
+		WarnKeyOptions(&input)
+		Expect(warnings.String()).To(Equal("Warning: keyOptions key 'shop-url' is not one of the keys, " +
+			"it is only used if keySelectors select it\n"))
+	})
+
+	It("should warn about deprecated fields", func() {
+		input, err := ParseInput([]byte(header + "  stage: prod\n  dc: be-gcw1\nkeys:\n- API_ENDPOINT\n"))
+		Expect(err).ToNot(HaveOccurred())
+		warnings := &bytes.Buffer{}
+		SetWarnings(&input, warnings)
+		WarnDeprecations(&input)
+		Expect(warnings.String()).To(Equal("Warning: metadata.stage is deprecated, use metadata.environment instead\n" +
+			"Warning: metadata.dc is deprecated, use metadata.tag instead\n"))
+	})
+})
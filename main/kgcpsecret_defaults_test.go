@@ -0,0 +1,112 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+var _ = Describe("when creating a Kubernetes secret with optional keys and defaults", func() {
+
+	It("should leave out optional keys that do not exist", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "secret1")
+		encryptedSecret.Keys = append(encryptedSecret.Keys, "feature-flag")
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"feature-flag": {Optional: true},
+		}
+		expected := createExpectedK8SSecret("my-secret", "secret1", "secret1-42")
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expected))
+	})
+
+	It("should use the default value of keys that do not exist", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "feature-flag")
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"feature-flag": {Default: stringPointer("off")},
+		}
+		expected := createExpectedK8SSecret("my-secret", "feature-flag", "b2Zm")
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expected))
+	})
+
+	It("should prefer the value from Secret Manager over the default", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "secret1")
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"secret1": {Default: stringPointer("off")},
+		}
+		expected := createExpectedK8SSecret("my-secret", "secret1", "secret1-42")
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expected))
+	})
+
+	It("should take the default from another key", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "read-password")
+		encryptedSecret.Keys = []string{"read-password", "write-password", "secret2"}
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"read-password":  {DefaultFrom: "write-password"},
+			"write-password": {DefaultFrom: "secret2"},
+		}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(map[string]string{
+			"read-password":  "secret2-42",
+			"write-password": "secret2-42",
+			"secret2":        "secret2-42",
+		}))
+	})
+
+	It("should fail if the key to take the default from has no value", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "read-password")
+		encryptedSecret.Keys = []string{"read-password", "write-password"}
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"read-password":  {DefaultFrom: "write-password"},
+			"write-password": {Optional: true},
+		}
+		expected := "error getting 'read-password' secret in Google project 'cf-2tier-uhd-test-d7'. " +
+			"key 'read-password' was not found and defaultFrom key 'write-password' has no value either"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValue)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(expected))
+	})
+
+	It("should not use defaults if reading an existing secret fails", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "secret1")
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"secret1": {Optional: true, Default: stringPointer("off")},
+		}
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValueFailure)
+		Expect(err).To(HaveOccurred())
+	})
+})
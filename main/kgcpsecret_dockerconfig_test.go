@@ -0,0 +1,127 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+// registry credentials as returned by Google Secret Manager, base64 encoded
+var registry_values = map[string]string{
+	"REGISTRY_USER":     base64.StdEncoding.EncodeToString([]byte("robot")),
+	"REGISTRY_PASSWORD": base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+	"DOCKER_CONFIG":     base64.StdEncoding.EncodeToString([]byte(`{"auths":{"ghcr.io":{"auth":"cm9ib3Q6czNjcjN0"}}}`)),
+	"BROKEN_CONFIG":     base64.StdEncoding.EncodeToString([]byte(`{"auths":`)),
+}
+
+func getRegistryTestValue(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+	if value, ok := registry_values[key]; ok {
+		return value, nil
+	}
+	return "", errors.New("no value found for key")
+}
+
+func getRegistryTestKeys(project_id string) ([]string, error) {
+	keys := []string{}
+	for k := range registry_values {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+var _ = Describe("when creating a kubernetes.io/dockerconfigjson secret", func() {
+	newPullSecretPlugin := func() KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("pull-secret", "")
+		encryptedSecret.Type = "kubernetes.io/dockerconfigjson"
+		encryptedSecret.Keys = nil
+		return encryptedSecret
+	}
+
+	It("should build the .dockerconfigjson from the registries", func() {
+		encryptedSecret := newPullSecretPlugin()
+		encryptedSecret.Registries = []Registry{{
+			Server:      "europe-docker.pkg.dev",
+			UsernameKey: "REGISTRY_USER",
+			PasswordKey: "REGISTRY_PASSWORD",
+			Email:       "robot@metro.digital",
+		}}
+		config := `{"auths":{"europe-docker.pkg.dev":{"username":"robot","password":"s3cr3t","email":"robot@metro.digital","auth":"cm9ib3Q6czNjcjN0"}}}`
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getRegistryTestKeys, getRegistryTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(map[string]string{
+			".dockerconfigjson": base64.StdEncoding.EncodeToString([]byte(config)),
+		}))
+	})
+
+	It("should keep credential keys which are also keys of the secret", func() {
+		encryptedSecret := newPullSecretPlugin()
+		encryptedSecret.Keys = []string{"REGISTRY_USER"}
+		encryptedSecret.Registries = []Registry{{
+			Server:      "europe-docker.pkg.dev",
+			UsernameKey: "REGISTRY_USER",
+			PasswordKey: "REGISTRY_PASSWORD",
+		}}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getRegistryTestKeys, getRegistryTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(HaveKey(".dockerconfigjson"))
+		Expect(actual.Data).To(HaveKeyWithValue("REGISTRY_USER", registry_values["REGISTRY_USER"]))
+		Expect(actual.Data).ToNot(HaveKey("REGISTRY_PASSWORD"))
+	})
+
+	It("should fail when a credential is missing", func() {
+		encryptedSecret := newPullSecretPlugin()
+		encryptedSecret.Registries = []Registry{{
+			Server:      "europe-docker.pkg.dev",
+			UsernameKey: "REGISTRY_USER",
+			PasswordKey: "OTHER_PASSWORD",
+		}}
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRegistryTestKeys, getRegistryTestValue)
+		Expect(err).To(MatchError(ContainSubstring("key 'OTHER_PASSWORD' was not found")))
+	})
+
+	It("should accept a valid .dockerconfigjson from Secret Manager", func() {
+		encryptedSecret := newPullSecretPlugin()
+		encryptedSecret.Keys = []string{"DOCKER_CONFIG", ".dockerconfigjson"}
+		encryptedSecret.KeyOptions = map[string]KeyOptions{".dockerconfigjson": {DefaultFrom: "DOCKER_CONFIG"}}
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRegistryTestKeys, getRegistryTestValue)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should reject an invalid .dockerconfigjson", func() {
+		encryptedSecret := newPullSecretPlugin()
+		encryptedSecret.Keys = []string{"BROKEN_CONFIG", ".dockerconfigjson"}
+		encryptedSecret.KeyOptions = map[string]KeyOptions{".dockerconfigjson": {DefaultFrom: "BROKEN_CONFIG"}}
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRegistryTestKeys, getRegistryTestValue)
+		Expect(err).To(MatchError(".dockerconfigjson is not valid JSON: unexpected end of JSON input"))
+	})
+})
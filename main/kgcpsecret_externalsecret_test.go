@@ -0,0 +1,124 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("when creating an ExternalSecret", func() {
+	newExternalSecretPlugin := func() KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "API_ENDPOINT")
+		encryptedSecret.Output = "externalsecret"
+		encryptedSecret.Type = "Opaque"
+		encryptedSecret.Keys = []string{"API_ENDPOINT", "FEATURES"}
+		encryptedSecret.ExternalSecret = ExternalSecretOptions{
+			SecretStoreRef:  SecretStoreRef{Name: "gcp-store", Kind: "ClusterSecretStore"},
+			RefreshInterval: "1h",
+		}
+		return encryptedSecret
+	}
+
+	It("should reference the resolved secrets instead of their values", func() {
+		encryptedSecret := newExternalSecretPlugin()
+		expected := K8SExternalSecret{
+			TypeMeta: TypeMeta{
+				APIVersion: "external-secrets.io/v1beta1",
+				Kind:       "ExternalSecret",
+			},
+			ObjectMeta: ObjectMeta{
+				Name:        "my-secret",
+				Labels:      map[string]string{},
+				Annotations: map[string]string{},
+			},
+			Spec: ExternalSecretSpec{
+				RefreshInterval: "1h",
+				SecretStoreRef:  SecretStoreRef{Name: "gcp-store", Kind: "ClusterSecretStore"},
+				Target: ExternalSecretTarget{
+					Name:     "my-secret",
+					Template: &ExternalSecretTemplate{Type: "Opaque"},
+				},
+				Data: []ExternalSecretData{
+					{SecretKey: "API_ENDPOINT", RemoteRef: ExternalSecretRemoteRef{Key: "API_ENDPOINT"}},
+					{SecretKey: "FEATURES", RemoteRef: ExternalSecretRemoteRef{Key: "FEATURES"}},
+				},
+			},
+		}
+
+		actual, err := GetExternalSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expected))
+	})
+
+	It("should only read the payloads to validate values", func() {
+		encryptedSecret := newExternalSecretPlugin()
+		Expect(ReadsPayloads(&encryptedSecret)).To(BeFalse())
+
+		encryptedSecret.KeyOptions = map[string]KeyOptions{"FEATURES": {Validate: ValueRules{Format: "json"}}}
+		Expect(ReadsPayloads(&encryptedSecret)).To(BeTrue())
+
+		encryptedSecret.Output = "secretproviderclass"
+		encryptedSecret.KeyOptions = nil
+		Expect(ReadsPayloads(&encryptedSecret)).To(BeFalse())
+
+		encryptedSecret.Output = "sops"
+		Expect(ReadsPayloads(&encryptedSecret)).To(BeTrue())
+	})
+
+	It("should pin the versions which were read", func() {
+		encryptedSecret := newExternalSecretPlugin()
+
+		actual, err := GetExternalSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getVersionedConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Spec.Data).To(Equal([]ExternalSecretData{
+			{SecretKey: "API_ENDPOINT", RemoteRef: ExternalSecretRemoteRef{Key: "API_ENDPOINT", Version: "7"}},
+			{SecretKey: "FEATURES", RemoteRef: ExternalSecretRemoteRef{Key: "FEATURES", Version: "7"}},
+		}))
+	})
+
+	It("should reference the secret of the defaultFrom key", func() {
+		encryptedSecret := newExternalSecretPlugin()
+		encryptedSecret.Keys = []string{"API_ENDPOINT", "API_FALLBACK"}
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"API_FALLBACK": {DefaultFrom: "API_ENDPOINT"},
+		}
+
+		actual, err := GetExternalSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Spec.Data).To(Equal([]ExternalSecretData{
+			{SecretKey: "API_ENDPOINT", RemoteRef: ExternalSecretRemoteRef{Key: "API_ENDPOINT"}},
+			{SecretKey: "API_FALLBACK", RemoteRef: ExternalSecretRemoteRef{Key: "API_ENDPOINT"}},
+		}))
+	})
+
+	It("should fail for keys with a literal default value", func() {
+		encryptedSecret := newExternalSecretPlugin()
+		defaultValue := "https://fallback.metro.digital"
+		encryptedSecret.Keys = []string{"API_ENDPOINT", "API_FALLBACK"}
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"API_FALLBACK": {Default: &defaultValue},
+		}
+
+		_, err := GetExternalSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).To(MatchError("key 'API_FALLBACK' has a default value, which cannot be referenced by an ExternalSecret"))
+	})
+})
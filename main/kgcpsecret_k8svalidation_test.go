@@ -0,0 +1,108 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+// values violating the rules of Kubernetes, base64 encoded
+var k8s_values = map[string]string{
+	"API_ENDPOINT":  base64.StdEncoding.EncodeToString([]byte("https://api.metro.digital")),
+	"api endpoint":  base64.StdEncoding.EncodeToString([]byte("https://api.metro.digital")),
+	"LARGE_PAYLOAD": base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 1024*1024+1))),
+}
+
+func getK8STestValue(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+	if value, ok := k8s_values[key]; ok {
+		return value, nil
+	}
+	return "", errors.New("no value found for key")
+}
+
+func getK8STestKeys(project_id string) ([]string, error) {
+	keys := []string{}
+	for k := range k8s_values {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+var _ = Describe("when validating the generated secret against the rules of Kubernetes", func() {
+	It("should accept a valid secret", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "API_ENDPOINT")
+		encryptedSecret.Namespace = "shop"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getK8STestKeys, getK8STestValue)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should report all violations at once", func() {
+		encryptedSecret := createEncryptedGCPSecret("My_Secret", "api endpoint")
+		encryptedSecret.Namespace = "Shop"
+		encryptedSecret.Type = "kubernetes.io/ssh-auth"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getK8STestKeys, getK8STestValue)
+		Expect(err).To(MatchError("secret 'My_Secret' would be rejected by Kubernetes:\n" +
+			"- name 'My_Secret' must be a lowercase DNS subdomain of at most 253 characters\n" +
+			"- namespace 'Shop' must be a lowercase DNS label of at most 63 characters\n" +
+			"- key 'api endpoint' must consist of alphanumeric characters, '-', '_' or '.'\n" +
+			"- type kubernetes.io/ssh-auth needs the key ssh-privatekey"))
+	})
+
+	It("should not require the keys of the type when merging into an existing secret", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "API_ENDPOINT")
+		encryptedSecret.Behavior = "merge"
+		for _, secretType := range []string{"kubernetes.io/ssh-auth", "kubernetes.io/tls", "kubernetes.io/dockerconfigjson",
+			"kubernetes.io/basic-auth"} {
+			encryptedSecret.Type = secretType
+
+			_, err := GetSecrets(ctx, nil, &encryptedSecret, getK8STestKeys, getK8STestValue)
+			Expect(err).ToNot(HaveOccurred(), secretType)
+		}
+	})
+
+	It("should leave room for the name suffix hash", func() {
+		encryptedSecret := createEncryptedGCPSecret(strings.Repeat("a", 250), "API_ENDPOINT")
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getK8STestKeys, getK8STestValue)
+		Expect(err).ToNot(HaveOccurred())
+
+		encryptedSecret.DisableNameSuffixHash = false
+		_, err = GetSecrets(ctx, nil, &encryptedSecret, getK8STestKeys, getK8STestValue)
+		Expect(err).To(MatchError(ContainSubstring("must be a lowercase DNS subdomain of at most 242 characters")))
+	})
+
+	It("should fail for secrets over the size limit", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "LARGE_PAYLOAD")
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getK8STestKeys, getK8STestValue)
+		Expect(err).To(MatchError(ContainSubstring("data has 1048577 bytes, more than the limit of 1048576 bytes")))
+	})
+})
@@ -0,0 +1,181 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	jks "github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+var _ = Describe("when creating a secret with keystores", func() {
+	var leafKey *ecdsa.PrivateKey
+	var leafPEM, intermediatePEM string
+	var values map[string]string
+
+	getKeystoreTestValue := func(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+		if value, ok := values[key]; ok {
+			return value, nil
+		}
+		return "", errors.New("no value found for key")
+	}
+	getKeystoreTestKeys := func(project_id string) ([]string, error) {
+		keys := []string{}
+		for k := range values {
+			keys = append(keys, k)
+		}
+		return keys, nil
+	}
+	newKeystorePlugin := func(keystore Keystore) KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("service-keystore", "")
+		encryptedSecret.Keys = nil
+		encryptedSecret.Keystores = []Keystore{keystore}
+		return encryptedSecret
+	}
+	keystoreContent := func(secret K8SSecret, key string) []byte {
+		content, err := base64.StdEncoding.DecodeString(secret.Data[key])
+		Expect(err).ToNot(HaveOccurred())
+		return content
+	}
+
+	BeforeEach(func() {
+		intermediate, intermediateKey, intermediateCert, _ := createTestCertificate("intermediate", time.Now().Add(time.Hour), nil, nil)
+		intermediatePEM = intermediateCert
+		var leafKeyPEM string
+		_, leafKey, leafPEM, leafKeyPEM = createTestCertificate("service", time.Now().Add(time.Hour), intermediate, intermediateKey)
+		values = encodeTestValues(map[string]string{
+			"SERVICE_CERT":      leafPEM,
+			"SERVICE_CHAIN":     intermediatePEM,
+			"SERVICE_KEY":       leafKeyPEM,
+			"KEYSTORE_PASSWORD": "changeit",
+		})
+	})
+
+	It("should build a PKCS#12 keystore with the private key and the certificate chain", func() {
+		encryptedSecret := newKeystorePlugin(Keystore{
+			Key:            "keystore.p12",
+			CertificateKey: "SERVICE_CERT",
+			ChainKey:       "SERVICE_CHAIN",
+			PrivateKeyKey:  "SERVICE_KEY",
+			PasswordKey:    "KEYSTORE_PASSWORD",
+		})
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getKeystoreTestKeys, getKeystoreTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(HaveLen(1))
+		privateKey, certificate, caCerts, err := pkcs12.DecodeChain(keystoreContent(actual, "keystore.p12"), "changeit")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(privateKey.(*ecdsa.PrivateKey).Equal(leafKey)).To(BeTrue())
+		Expect(certificate.Subject.CommonName).To(Equal("service"))
+		Expect(caCerts).To(HaveLen(1))
+		Expect(caCerts[0].Subject.CommonName).To(Equal("intermediate"))
+	})
+
+	It("should build a PKCS#12 truststore without a private key", func() {
+		encryptedSecret := newKeystorePlugin(Keystore{
+			Key:            "truststore.p12",
+			CertificateKey: "SERVICE_CHAIN",
+			PasswordKey:    "KEYSTORE_PASSWORD",
+		})
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getKeystoreTestKeys, getKeystoreTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		certificates, err := pkcs12.DecodeTrustStore(keystoreContent(actual, "truststore.p12"), "changeit")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(certificates).To(HaveLen(1))
+		Expect(certificates[0].Subject.CommonName).To(Equal("intermediate"))
+	})
+
+	It("should build a JKS keystore", func() {
+		encryptedSecret := newKeystorePlugin(Keystore{
+			Key:            "keystore.jks",
+			Format:         "jks",
+			CertificateKey: "SERVICE_CERT",
+			ChainKey:       "SERVICE_CHAIN",
+			PrivateKeyKey:  "SERVICE_KEY",
+			PasswordKey:    "KEYSTORE_PASSWORD",
+			Alias:          "service",
+		})
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getKeystoreTestKeys, getKeystoreTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		store := jks.New()
+		Expect(store.Load(bytes.NewReader(keystoreContent(actual, "keystore.jks")), []byte("changeit"))).To(Succeed())
+		entry, err := store.GetPrivateKeyEntry("service", []byte("changeit"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entry.CertificateChain).To(HaveLen(2))
+	})
+
+	It("should build the same keystores on every build", func() {
+		for _, format := range []string{"pkcs12", "jks"} {
+			encryptedSecret := newKeystorePlugin(Keystore{
+				Key:            "keystore",
+				Format:         format,
+				CertificateKey: "SERVICE_CERT",
+				ChainKey:       "SERVICE_CHAIN",
+				PrivateKeyKey:  "SERVICE_KEY",
+				PasswordKey:    "KEYSTORE_PASSWORD",
+			})
+			encryptedSecret.Keystores = append(encryptedSecret.Keystores, Keystore{
+				Key:            "truststore",
+				Format:         format,
+				CertificateKey: "SERVICE_CERT",
+				ChainKey:       "SERVICE_CHAIN",
+				PasswordKey:    "KEYSTORE_PASSWORD",
+			})
+
+			first, err := GetSecrets(ctx, nil, &encryptedSecret, getKeystoreTestKeys, getKeystoreTestValue)
+			Expect(err).ToNot(HaveOccurred())
+			second, err := GetSecrets(ctx, nil, &encryptedSecret, getKeystoreTestKeys, getKeystoreTestValue)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(second.Data).To(Equal(first.Data), format)
+
+			values["KEYSTORE_PASSWORD"] = base64.StdEncoding.EncodeToString([]byte("changed"))
+			changed, err := GetSecrets(ctx, nil, &encryptedSecret, getKeystoreTestKeys, getKeystoreTestValue)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed.Data["keystore"]).ToNot(Equal(first.Data["keystore"]), format)
+			values["KEYSTORE_PASSWORD"] = base64.StdEncoding.EncodeToString([]byte("changeit"))
+		}
+	})
+
+	It("should fail when the private key does not match the certificate", func() {
+		encryptedSecret := newKeystorePlugin(Keystore{
+			Key:            "keystore.p12",
+			CertificateKey: "SERVICE_CHAIN",
+			PrivateKeyKey:  "SERVICE_KEY",
+			PasswordKey:    "KEYSTORE_PASSWORD",
+		})
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getKeystoreTestKeys, getKeystoreTestValue)
+		Expect(err).To(MatchError("failed to build keystore 'keystore.p12': certificate and private key are not a valid key pair: tls: private key does not match public key"))
+	})
+})
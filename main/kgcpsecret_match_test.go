@@ -0,0 +1,88 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("when creating a Kubernetes secret with a required match", func() {
+
+	It("should use secrets matching the environment", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "CASSANDRA_URL")
+		encryptedSecret.Environment = "prod"
+		encryptedSecret.Tag = "nl-gcw4"
+		encryptedSecret.RequireMatch = "environment"
+		expected := createExpectedK8SSecret("my-secret", "CASSANDRA_URL", "cassandra-prod.be-gcw1.metro.digital")
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getPostfixTestKeys, getPostfixTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expected))
+	})
+
+	It("should not fall back to a secret without the environment", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "CDN_URL")
+		encryptedSecret.Environment = "prod"
+		encryptedSecret.Tag = "be-gcw1"
+		encryptedSecret.RequireMatch = "environment"
+		expected := "error getting 'CDN_URL' secret in Google project 'cf-2tier-uhd-test-d7'. " +
+			"only secret 'CDN_URL' exists, which is less specific than requireMatch 'environment' allows"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getPostfixTestKeys, getPostfixTestValue)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(expected))
+	})
+
+	It("should require environment and tag if configured for the key", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "CASSANDRA_URL")
+		encryptedSecret.Environment = "prod"
+		encryptedSecret.Tag = "nl-gcw4"
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"CASSANDRA_URL": {RequireMatch: "environment+tag"},
+		}
+		expected := "error getting 'CASSANDRA_URL' secret in Google project 'cf-2tier-uhd-test-d7'. " +
+			"only secret 'CASSANDRA_URL_prod' exists, which is less specific than requireMatch 'environment+tag' allows"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getPostfixTestKeys, getPostfixTestValue)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(expected))
+
+		encryptedSecret.Tag = "cn-tcs1"
+		expectedSecret := createExpectedK8SSecret("my-secret", "CASSANDRA_URL", "cassandra-prod.cn-tcs1.metro.digital")
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getPostfixTestKeys, getPostfixTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expectedSecret))
+	})
+
+	It("should only use the most specific secret for an exact match", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "CASSANDRA_URL")
+		encryptedSecret.Environment = "prod"
+		encryptedSecret.Tag = "cn-tcs1"
+		encryptedSecret.RequireMatch = "exact"
+		expected := "error getting 'CASSANDRA_URL' secret in Google project 'cf-2tier-uhd-test-d7'. " +
+			"only secret 'CASSANDRA_URL_prod_cn-tcs1' exists, which is less specific than requireMatch 'exact' allows"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getPostfixTestKeys, getPostfixTestValue)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(expected))
+	})
+})
@@ -0,0 +1,87 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"gopkg.in/yaml.v2"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("when reading labels and annotations", func() {
+	decode := func(metadata string) (KGCPSecret, error) {
+		input := KGCPSecret{}
+		err := yaml.Unmarshal([]byte("metadata:\n  name: shop\n"+metadata), &input)
+		return input, err
+	}
+
+	It("should accept a map", func() {
+		input, err := decode("  labels:\n    app: shop\n    version: 2\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(input.Labels).To(BeEquivalentTo(map[string]string{"app": "shop", "version": "2"}))
+	})
+
+	It("should accept a list of single entry maps", func() {
+		input, err := decode("  annotations:\n    - annotation1: do-this\n    - annotation2: do-that\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(input.Annotations).To(BeEquivalentTo(map[string]string{"annotation1": "do-this", "annotation2": "do-that"}))
+	})
+
+	It("should accept a list of key=value strings", func() {
+		input, err := decode("  labels:\n    - app=shop\n    - tier=web=frontend\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(input.Labels).To(BeEquivalentTo(map[string]string{"app": "shop", "tier": "web=frontend"}))
+	})
+
+	It("should report duplicate keys", func() {
+		_, err := decode("  labels:\n    - app: shop\n    - app=checkout\n")
+		Expect(err).To(MatchError(ContainSubstring("label or annotation 'app' is set more than once")))
+
+		_, err = decode("  labels:\n    app: shop\n    app: checkout\n")
+		Expect(err).To(MatchError(ContainSubstring("label or annotation 'app' is set more than once")))
+	})
+
+	It("should reject malformed entries", func() {
+		_, err := decode("  labels:\n    - app\n")
+		Expect(err).To(MatchError(ContainSubstring("label or annotation 'app' must have the form key=value")))
+
+		_, err = decode("  labels:\n    - app: shop\n      tier: web\n")
+		Expect(err).To(MatchError(ContainSubstring("must have a single key")))
+
+		_, err = decode("  labels:\n    app:\n      nested: value\n")
+		Expect(err).To(MatchError(ContainSubstring("label or annotation 'app' must have a string value")))
+	})
+
+	It("should pass further metadata fields to the generated secret", func() {
+		input, err := decode("  labels:\n    - app: shop\n  finalizers:\n    - metro.digital/cleanup\n")
+		Expect(err).ToNot(HaveOccurred())
+		input.GCPProjectID = "cf-2tier-uhd-test-d7"
+		input.DisableNameSuffixHash = true
+		input.Keys = []string{"API_ENDPOINT"}
+
+		actual, err := GetSecrets(ctx, nil, &input, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		output, err := yaml.Marshal(actual.ObjectMeta)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(output)).To(Equal("name: shop\nlabels:\n  app: shop\nfinalizers:\n- metro.digital/cleanup\n"))
+	})
+})
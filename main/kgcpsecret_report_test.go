@@ -0,0 +1,95 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("when creating a Kubernetes secret with several failing keys", func() {
+	encryptedSecret := createEncryptedGCPSecret("my-secret", "do-not-exist")
+	encryptedSecret.Keys = []string{"do-not-exist", "secret1", "missing-too"}
+
+	It("should report all failing keys at once", func() {
+		expected := "2 keys could not be resolved:\n" +
+			"- error getting 'do-not-exist' secret in Google project 'cf-2tier-uhd-test-d7'. key 'do-not-exist' was not found\n" +
+			"- error getting 'missing-too' secret in Google project 'cf-2tier-uhd-test-d7'. key 'missing-too' was not found"
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValue)
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(expected))
+	})
+
+	It("should list the failing keys with their candidates in the JSON report", func() {
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValueFailure)
+		Expect(err).To(HaveOccurred())
+
+		output := &bytes.Buffer{}
+		WriteErrorReport(output, err, "json")
+
+		var report struct {
+			Message string
+			Keys    []struct {
+				Key        string
+				Project    string
+				Category   string
+				Candidates []string
+				Message    string
+			}
+		}
+		Expect(json.Unmarshal(output.Bytes(), &report)).To(Succeed())
+		Expect(report.Keys).To(HaveLen(3))
+		Expect(report.Keys[0].Key).To(Equal("do-not-exist"))
+		Expect(report.Keys[0].Category).To(Equal("not-found"))
+		Expect(report.Keys[1].Key).To(Equal("secret1"))
+		Expect(report.Keys[1].Project).To(Equal("cf-2tier-uhd-test-d7"))
+		Expect(report.Keys[1].Category).To(Equal("access-failed"))
+		Expect(report.Keys[1].Message).To(Equal("helpful error message"))
+		Expect(report.Keys[1].Candidates).To(HaveLen(16))
+		Expect(report.Keys[1].Candidates[0]).To(Equal("_my-secret_secret1__"))
+		Expect(report.Keys[1].Candidates[15]).To(Equal("secret1"))
+	})
+
+	It("should list the tried candidates in the text report", func() {
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValue)
+		Expect(err).To(HaveOccurred())
+
+		output := &bytes.Buffer{}
+		WriteErrorReport(output, err, "text")
+		Expect(output.String()).To(HavePrefix("Error: 2 keys could not be resolved:\n" +
+			"- error getting 'do-not-exist' secret in Google project 'cf-2tier-uhd-test-d7'. key 'do-not-exist' was not found (not-found)\n" +
+			"  tried: _my-secret_do-not-exist__, "))
+	})
+	It("should count a single failing key in the text report", func() {
+		singleSecret := createEncryptedGCPSecret("my-secret", "do-not-exist")
+		_, err := GetSecrets(ctx, nil, &singleSecret, getBaseTestKeys, getBaseTestValue)
+		Expect(err).To(HaveOccurred())
+
+		output := &bytes.Buffer{}
+		WriteErrorReport(output, err, "text")
+		Expect(output.String()).To(HavePrefix("Error: 1 key could not be resolved:\n" +
+			"- error getting 'do-not-exist' secret in Google project 'cf-2tier-uhd-test-d7'."))
+	})
+})
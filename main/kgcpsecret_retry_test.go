@@ -0,0 +1,109 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func callWithRetry(plugin *KGCPSecret, operation string, f func(ctx context.Context) error) error {
+	call, err := NewGCPCaller(plugin)
+	if err != nil {
+		return err
+	}
+	return call(operation, f)
+}
+
+func failingCall(attempts *int, failures int, code codes.Code) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		*attempts++
+		if *attempts <= failures {
+			return status.Error(code, "try again later")
+		}
+		return nil
+	}
+}
+
+var _ = Describe("when calling Google Secret Manager", func() {
+	encryptedSecret := createEncryptedGCPSecret("my-secret", "db-password")
+	encryptedSecret.Retry = RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: "1ms",
+		MaxBackoff:     "2ms",
+	}
+
+	It("should retry temporary failures", func() {
+		attempts := 0
+		err := callWithRetry(&encryptedSecret, "access secret db-password", failingCall(&attempts, 2, codes.Unavailable))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should report when the retries are exhausted", func() {
+		attempts := 0
+		expected := "giving up to access secret db-password after 3 attempts: rpc error: code = ResourceExhausted desc = try again later"
+		err := callWithRetry(&encryptedSecret, "access secret db-password", failingCall(&attempts, 5, codes.ResourceExhausted))
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(expected))
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should not retry permanent failures", func() {
+		attempts := 0
+		err := callWithRetry(&encryptedSecret, "access secret db-password", failingCall(&attempts, 5, codes.NotFound))
+
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("should limit the requests per second", func() {
+		limited := encryptedSecret
+		limited.RequestsPerSecond = 20
+		call, err := NewGCPCaller(&limited)
+		Expect(err).ToNot(HaveOccurred())
+
+		attempts := 0
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			Expect(call("list secrets", failingCall(&attempts, 0, codes.OK))).To(Succeed())
+		}
+		Expect(attempts).To(Equal(3))
+		Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+	})
+
+	It("should reject invalid policies", func() {
+		invalid := encryptedSecret
+		invalid.Retry.CallTimeout = "soon"
+		err := callWithRetry(&invalid, "list secrets", failingCall(new(int), 0, codes.OK))
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("retry.callTimeout must be a positive duration like '30s', got 'soon'"))
+	})
+})
@@ -0,0 +1,149 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+// secrets of the environments prod and staging
+var rule_values = encodeTestValues(map[string]string{
+	"API_TOKEN_prod":      "prod-token-0123456789",
+	"API_TOKEN_staging":   "staging-token-0123456789",
+	"DB_PASSWORD_prod":    "shared",
+	"DB_PASSWORD_staging": "shared",
+	"CONFIG":              `{"replicas": 3`,
+	"ENDPOINT":            "https://api.metro.digital/v1",
+	"CLIENT_ID":           "TODO",
+	"SETTINGS":            "replicas: 3\n",
+	"CERTIFICATE":         "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+	"NOTES":               "replicas are set elsewhere\n-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+})
+
+func getRuleTestValue(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+	if value, ok := rule_values[key]; ok {
+		return value, nil
+	}
+	return "", errors.New("no value found for key")
+}
+
+func getRuleTestKeys(project_id string) ([]string, error) {
+	keys := []string{}
+	for k := range rule_values {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+var _ = Describe("when validating values", func() {
+	newRulesPlugin := func(key string, rules ValueRules) KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", key)
+		encryptedSecret.Environment = "prod"
+		encryptedSecret.KeyOptions = map[string]KeyOptions{key: {Validate: rules}}
+		return encryptedSecret
+	}
+
+	It("should accept values fulfilling all rules", func() {
+		encryptedSecret := newRulesPlugin("API_TOKEN", ValueRules{
+			Regex:                 "^[a-z0-9-]+$",
+			NotRegex:              "TODO",
+			MinLength:             16,
+			MaxLength:             64,
+			NotEqualToEnvironment: "staging",
+		})
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should fail for a placeholder", func() {
+		encryptedSecret := newRulesPlugin("CLIENT_ID", ValueRules{NotRegex: "^(TODO|changeme)$"})
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).To(MatchError("error getting 'CLIENT_ID' secret in Google project 'cf-2tier-uhd-test-d7'. value matches notRegex '^(TODO|changeme)$'"))
+	})
+
+	It("should fail for a value that is too short", func() {
+		encryptedSecret := newRulesPlugin("CLIENT_ID", ValueRules{MinLength: 8})
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).To(MatchError(ContainSubstring("value is shorter than minLength 8")))
+	})
+
+	It("should check the format of values", func() {
+		encryptedSecret := newRulesPlugin("CONFIG", ValueRules{Format: "json"})
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).To(MatchError(ContainSubstring("value is not valid json")))
+
+		encryptedSecret = newRulesPlugin("ENDPOINT", ValueRules{Format: "url"})
+		_, err = GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).ToNot(HaveOccurred())
+
+		encryptedSecret = newRulesPlugin("ENDPOINT", ValueRules{Format: "uuid"})
+		_, err = GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).To(MatchError(ContainSubstring("value is not valid uuid")))
+	})
+
+	It("should accept only a mapping or a sequence as yaml", func() {
+		encryptedSecret := newRulesPlugin("SETTINGS", ValueRules{Format: "yaml"})
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).ToNot(HaveOccurred())
+
+		encryptedSecret = newRulesPlugin("CLIENT_ID", ValueRules{Format: "yaml"})
+		_, err = GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).To(MatchError(ContainSubstring("value is not valid yaml")))
+	})
+
+	It("should accept only values starting with a pem block as pem", func() {
+		encryptedSecret := newRulesPlugin("CERTIFICATE", ValueRules{Format: "pem"})
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).ToNot(HaveOccurred())
+
+		encryptedSecret = newRulesPlugin("NOTES", ValueRules{Format: "pem"})
+		_, err = GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).To(MatchError(ContainSubstring("value is not valid pem")))
+	})
+
+	It("should fail for a value equal to the one in another environment", func() {
+		encryptedSecret := newRulesPlugin("DB_PASSWORD", ValueRules{NotEqualToEnvironment: "staging"})
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).To(MatchError(ContainSubstring("value is equal to the value in environment 'staging'")))
+	})
+
+	It("should report the category invalid-value", func() {
+		encryptedSecret := newRulesPlugin("CLIENT_ID", ValueRules{Format: "uuid"})
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getRuleTestKeys, getRuleTestValue)
+		Expect(err).To(HaveOccurred())
+		var report bytes.Buffer
+		WriteErrorReport(&report, err, "json")
+		Expect(report.String()).To(ContainSubstring(`"category":"invalid-value"`))
+	})
+})
@@ -0,0 +1,154 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// createSealingCertificate creates a self-signed certificate like the one of the sealed-secrets controller
+func createSealingCertificate() (*rsa.PrivateKey, string) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).ToNot(HaveOccurred())
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	Expect(err).ToNot(HaveOccurred())
+	return privateKey, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}))
+}
+
+// unseal decrypts a value like the sealed-secrets controller
+func unseal(privateKey *rsa.PrivateKey, value string, label string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(value)
+	Expect(err).ToNot(HaveOccurred())
+	keyLength := int(binary.BigEndian.Uint16(ciphertext))
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, ciphertext[2:2+keyLength], []byte(label))
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	Expect(err).ToNot(HaveOccurred())
+	aead, err := cipher.NewGCM(block)
+	Expect(err).ToNot(HaveOccurred())
+	plaintext, err := aead.Open(nil, make([]byte, aead.NonceSize()), ciphertext[2+keyLength:], nil)
+	return string(plaintext), err
+}
+
+var _ = Describe("when creating a SealedSecret", func() {
+	var privateKey *rsa.PrivateKey
+	var certificate string
+
+	BeforeEach(func() {
+		privateKey, certificate = createSealingCertificate()
+	})
+
+	newSealedSecretPlugin := func(scope string) KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "API_ENDPOINT")
+		encryptedSecret.Namespace = "shop"
+		encryptedSecret.Output = "sealedsecret"
+		encryptedSecret.Type = "Opaque"
+		encryptedSecret.DisableNameSuffixHash = false
+		encryptedSecret.Labels = map[string]string{"app": "shop"}
+		encryptedSecret.Keys = []string{"API_ENDPOINT", "LOGO"}
+		encryptedSecret.SealedSecret = SealedSecretOptions{Certificate: certificate, Scope: scope}
+		return encryptedSecret
+	}
+
+	It("should seal the values for the name and namespace of the secret", func() {
+		encryptedSecret := newSealedSecretPlugin("")
+
+		actual, err := GetSealedSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.APIVersion).To(Equal("bitnami.com/v1alpha1"))
+		Expect(actual.Kind).To(Equal("SealedSecret"))
+		Expect(actual.ObjectMeta).To(BeEquivalentTo(ObjectMeta{
+			Name:        "my-secret",
+			Namespace:   "shop",
+			Labels:      map[string]string{"app": "shop"},
+			Annotations: map[string]string{},
+		}))
+		Expect(actual.Spec.Template.ObjectMeta).To(BeEquivalentTo(ObjectMeta{
+			Name:        "my-secret",
+			Namespace:   "shop",
+			Labels:      map[string]string{"app": "shop"},
+			Annotations: map[string]string{},
+		}))
+		Expect(actual.Spec.Template.Type).To(Equal("Opaque"))
+		Expect(actual.Spec.EncryptedData).To(HaveLen(2))
+
+		Expect(unseal(privateKey, actual.Spec.EncryptedData["API_ENDPOINT"], "shop/my-secret")).To(Equal("https://api.metro.digital"))
+		logo, err := unseal(privateKey, actual.Spec.EncryptedData["LOGO"], "shop/my-secret")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(base64.StdEncoding.EncodeToString([]byte(logo))).To(Equal("iVBORw0KGgo="))
+
+		_, err = unseal(privateKey, actual.Spec.EncryptedData["API_ENDPOINT"], "shop/other-secret")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should seal the values for the namespace with scope namespace-wide", func() {
+		encryptedSecret := newSealedSecretPlugin("namespace-wide")
+
+		actual, err := GetSealedSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Annotations).To(BeEquivalentTo(map[string]string{
+			"sealedsecrets.bitnami.com/namespace-wide": "true",
+		}))
+		Expect(unseal(privateKey, actual.Spec.EncryptedData["API_ENDPOINT"], "shop")).To(Equal("https://api.metro.digital"))
+	})
+
+	It("should seal the values without label with scope cluster-wide", func() {
+		encryptedSecret := newSealedSecretPlugin("cluster-wide")
+
+		actual, err := GetSealedSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Annotations).To(BeEquivalentTo(map[string]string{
+			"sealedsecrets.bitnami.com/cluster-wide": "true",
+		}))
+		Expect(actual.Spec.Template.Annotations).To(BeEmpty())
+		Expect(unseal(privateKey, actual.Spec.EncryptedData["API_ENDPOINT"], "")).To(Equal("https://api.metro.digital"))
+	})
+
+	It("should fail for an invalid certificate", func() {
+		encryptedSecret := newSealedSecretPlugin("")
+		encryptedSecret.SealedSecret.Certificate = "not a certificate"
+
+		_, err := GetSealedSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).To(MatchError("the sealed-secrets certificate is not a PEM encoded certificate"))
+	})
+})
@@ -0,0 +1,97 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"context"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+// getVersionedConfigTestValue returns the config values and records that version 7 of each secret was read
+func getVersionedConfigTestValue(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+	value, err := getConfigTestValue(ctx, client, plugin, key)
+	if err == nil {
+		RecordSecretVersion(plugin, key, "projects/123456/secrets/"+key+"/versions/7")
+	}
+	return value, err
+}
+
+var _ = Describe("when creating a SecretProviderClass", func() {
+	newSecretProviderClassPlugin := func() KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "API_ENDPOINT")
+		encryptedSecret.Output = "secretproviderclass"
+		encryptedSecret.Keys = []string{"API_ENDPOINT", "LOGO"}
+		encryptedSecret.SecretProviderClass = SecretProviderClassOptions{
+			Paths: map[string]string{"LOGO": "images/logo.png"},
+		}
+		return encryptedSecret
+	}
+
+	It("should list the resolved secret versions with their paths", func() {
+		encryptedSecret := newSecretProviderClassPlugin()
+		expected := K8SSecretProviderClass{
+			TypeMeta: TypeMeta{
+				APIVersion: "secrets-store.csi.x-k8s.io/v1",
+				Kind:       "SecretProviderClass",
+			},
+			ObjectMeta: ObjectMeta{
+				Name:        "my-secret",
+				Labels:      map[string]string{},
+				Annotations: map[string]string{},
+			},
+			Spec: SecretProviderClassSpec{
+				Provider: "gcp",
+				Parameters: map[string]string{
+					"secrets": "- resourceName: projects/cf-2tier-uhd-test-d7/secrets/API_ENDPOINT/versions/7\n" +
+						"  path: API_ENDPOINT\n" +
+						"- resourceName: projects/cf-2tier-uhd-test-d7/secrets/LOGO/versions/7\n" +
+						"  path: images/logo.png\n",
+				},
+			},
+		}
+
+		actual, err := GetSecretProviderClass(ctx, nil, &encryptedSecret, getConfigTestKeys, getVersionedConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expected))
+	})
+
+	It("should sync the files into a Kubernetes secret", func() {
+		encryptedSecret := newSecretProviderClassPlugin()
+		encryptedSecret.SecretProviderClass.SyncSecret = true
+
+		actual, err := GetSecretProviderClass(ctx, nil, &encryptedSecret, getConfigTestKeys, getVersionedConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Spec.SecretObjects).To(Equal([]SecretProviderClassObject{{
+			SecretName:  "my-secret",
+			Type:        "Opaque",
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+			Data: []SecretProviderClassObjectData{
+				{ObjectName: "API_ENDPOINT", Key: "API_ENDPOINT"},
+				{ObjectName: "images/logo.png", Key: "LOGO"},
+			},
+		}}))
+	})
+})
@@ -0,0 +1,139 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"bytes"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("when creating a Kubernetes secret with key selectors", func() {
+
+	It("should add the keys whose base name matches a glob", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "dockercfg1_data")
+		encryptedSecret.Namespace = "my-namespace"
+		encryptedSecret.KeySelectors = []KeySelector{{Glob: "VALUE*"}}
+		explain := EnableExplanation(&encryptedSecret)
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getPrefixTestKeys, getPrefixTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(map[string]string{
+			"dockercfg1_data": "my-dockercfg1-data-value",
+			"VALUE1":          "my-secret-VALUE1-value",
+			"VALUE2":          "my-namespace-VALUE2-value",
+			"VALUE3":          "my-namespace-and-secret-VALUE3-value",
+		}))
+
+		output := &bytes.Buffer{}
+		explain(output)
+		Expect(output.String()).To(HavePrefix("keys selected by keySelectors: VALUE1, VALUE2, VALUE3\n"))
+	})
+
+	It("should add the keys whose base name matches a regex", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "dockercfg1_data")
+		encryptedSecret.KeySelectors = []KeySelector{{Regex: "^VALUE[12]$"}}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getPrefixTestKeys, getPrefixTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(HaveLen(3))
+		Expect(actual.Data).To(HaveKey("VALUE1"))
+		Expect(actual.Data).To(HaveKey("VALUE2"))
+	})
+
+	It("should strip the environment from selected secrets", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "CDN_URL")
+		encryptedSecret.Environment = "prod"
+		encryptedSecret.Tag = "cn-tcs1"
+		encryptedSecret.KeySelectors = []KeySelector{{Glob: "*_URL"}}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getPostfixTestKeys, getPostfixTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(map[string]string{
+			"CDN_URL":        "https://asia.cdn.net",
+			"CASSANDRA_URL":  "cassandra-prod.cn-tcs1.metro.digital",
+			"KUBERNETES_URL": "https://kubernetes-prod.metro.digital",
+		}))
+	})
+
+	It("should select shared secrets with an environment", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "KUBERNETES_URL")
+		encryptedSecret.Environment = "pp"
+		encryptedSecret.KeySelectors = []KeySelector{{Glob: "*_URL"}}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getPostfixTestKeys, getPostfixTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(map[string]string{
+			"KUBERNETES_URL": "https://kubernetes-pp.metro.digital",
+			"CDN_URL":        "https://europe.cdn.net",
+			"CASSANDRA_URL":  "cassandra-pp.be-gcw1.metro.digital",
+		}))
+	})
+
+	It("should not select the secrets of other environments", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "CDN_URL")
+		encryptedSecret.Environment = "prod"
+		encryptedSecret.Tag = "cn-tcs1"
+		encryptedSecret.KeySelectors = []KeySelector{{Glob: "CASSANDRA*"}}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getPostfixTestKeys, getPostfixTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(map[string]string{
+			"CDN_URL":       "https://asia.cdn.net",
+			"CASSANDRA_URL": "cassandra-prod.cn-tcs1.metro.digital",
+		}))
+	})
+
+	It("should not select the secrets of other environments with a label selector", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "CDN_URL")
+		encryptedSecret.Environment = "pp"
+		encryptedSecret.KeySelectors = []KeySelector{{Labels: "app=cassandra"}}
+		SetLabeledSecretsLister(&encryptedSecret, func(projectID string, f string) ([]string, error) {
+			return []string{"CASSANDRA_URL_pp", "CASSANDRA_URL_prod", "CASSANDRA_URL_prod_ru-tcm1"}, nil
+		})
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getPostfixTestKeys, getPostfixTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(map[string]string{
+			"CDN_URL":       "https://europe.cdn.net",
+			"CASSANDRA_URL": "cassandra-pp.be-gcw1.metro.digital",
+		}))
+	})
+
+	It("should add the secrets matching a label selector", func() {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "secret1")
+		encryptedSecret.KeySelectors = []KeySelector{{Labels: "app=checkout,team"}}
+		filter := ""
+		SetLabeledSecretsLister(&encryptedSecret, func(projectID string, f string) ([]string, error) {
+			filter = f
+			return []string{"secret3"}, nil
+		})
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getBaseTestKeys, getBaseTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter).To(Equal("labels.app=checkout AND labels.team:*"))
+		Expect(actual.Data).To(BeEquivalentTo(map[string]string{
+			"secret1": "secret1-42",
+			"secret3": "val-secret3",
+		}))
+	})
+})
@@ -0,0 +1,268 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v2"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(str|int|float|bool)\]$`)
+
+// decryptSOPSValue decrypts a value like SOPS and returns it with its type
+func decryptSOPSValue(value string, dataKey []byte, additionalData string) (string, string) {
+	parts := sopsValue.FindStringSubmatch(value)
+	Expect(parts).To(HaveLen(5))
+	data, _ := base64.StdEncoding.DecodeString(parts[1])
+	iv, _ := base64.StdEncoding.DecodeString(parts[2])
+	tag, _ := base64.StdEncoding.DecodeString(parts[3])
+	block, err := aes.NewCipher(dataKey)
+	Expect(err).ToNot(HaveOccurred())
+	aead, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	Expect(err).ToNot(HaveOccurred())
+	plaintext, err := aead.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	Expect(err).ToNot(HaveOccurred())
+	return string(plaintext), parts[4]
+}
+
+func sopsBranch(document yaml.MapSlice, key string) yaml.MapSlice {
+	for _, item := range document {
+		if item.Key == key {
+			return item.Value.(yaml.MapSlice)
+		}
+	}
+	return nil
+}
+
+// sopsBool formats a boolean for the message authentication code like SOPS
+func sopsBool(value bool) string {
+	if value {
+		return "True"
+	}
+	return "False"
+}
+
+// sopsDecrypt decrypts a document like 'sops -d' does: it decrypts the data key for the age identity, decrypts
+// the values with their path as additional data and verifies the message authentication code
+func sopsDecrypt(document yaml.MapSlice, identity *age.X25519Identity) yaml.MapSlice {
+	metadata := sopsBranch(document, "sops")
+	Expect(metadata).ToNot(BeNil())
+	settings := map[interface{}]interface{}{}
+	for _, item := range metadata {
+		settings[item.Key] = item.Value
+	}
+	var dataKey []byte
+	for _, recipient := range settings["age"].([]yaml.MapSlice) {
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(recipient[1].Value.(string))), identity)
+		if err == nil {
+			dataKey, err = ioutil.ReadAll(r)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+	Expect(dataKey).To(HaveLen(32))
+	macOnlyEncrypted := settings["mac_only_encrypted"] == true
+
+	mac := sha512.New()
+	var walk func(node interface{}, path []string) interface{}
+	walk = func(node interface{}, path []string) interface{} {
+		switch value := node.(type) {
+		case yaml.MapSlice:
+			decrypted := yaml.MapSlice{}
+			for _, item := range value {
+				decrypted = append(decrypted, yaml.MapItem{Key: item.Key, Value: walk(item.Value, append(append([]string{}, path...), fmt.Sprint(item.Key)))})
+			}
+			return decrypted
+		case []interface{}:
+			decrypted := []interface{}{}
+			for _, element := range value {
+				decrypted = append(decrypted, walk(element, path))
+			}
+			return decrypted
+		case nil:
+			return nil
+		}
+		if value, ok := node.(string); ok && strings.HasPrefix(value, "ENC[") {
+			plaintext, valueType := decryptSOPSValue(value, dataKey, strings.Join(path, ":")+":")
+			if valueType == "bool" {
+				mac.Write([]byte(sopsBool(plaintext == "true")))
+			} else {
+				mac.Write([]byte(plaintext))
+			}
+			return plaintext
+		}
+		if !macOnlyEncrypted {
+			if value, ok := node.(bool); ok {
+				mac.Write([]byte(sopsBool(value)))
+			} else {
+				mac.Write([]byte(fmt.Sprint(node)))
+			}
+		}
+		return node
+	}
+
+	decrypted := yaml.MapSlice{}
+	for _, item := range document {
+		if item.Key != "sops" {
+			decrypted = append(decrypted, yaml.MapItem{Key: item.Key, Value: walk(item.Value, []string{fmt.Sprint(item.Key)})})
+		}
+	}
+	expectedMAC, _ := decryptSOPSValue(settings["mac"].(string), dataKey, settings["lastmodified"].(string))
+	Expect(fmt.Sprintf("%X", mac.Sum(nil))).To(Equal(expectedMAC), "message authentication code")
+	return decrypted
+}
+
+var _ = Describe("when creating a SOPS encrypted secret", func() {
+	var identity *age.X25519Identity
+
+	BeforeEach(func() {
+		var err error
+		identity, err = age.GenerateX25519Identity()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	newSOPSPlugin := func() KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "API_ENDPOINT")
+		encryptedSecret.Output = "sops"
+		encryptedSecret.DisableNameSuffixHash = false
+		encryptedSecret.SOPS = SOPSOptions{Age: []string{identity.Recipient().String()}}
+		return encryptedSecret
+	}
+
+	It("should encrypt only data for the age recipients", func() {
+		encryptedSecret := newSOPSPlugin()
+
+		document, err := GetSOPSSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+
+		keys := []interface{}{}
+		for _, item := range document {
+			keys = append(keys, item.Key)
+		}
+		Expect(keys).To(Equal([]interface{}{"apiVersion", "kind", "metadata", "data", "sops"}))
+		Expect(sopsBranch(document, "metadata")).To(Equal(yaml.MapSlice{{Key: "name", Value: "my-secret"}}))
+		Expect(sopsBranch(document, "data")[0].Value).To(HavePrefix("ENC[AES256_GCM,"))
+
+		metadata := sopsBranch(document, "sops")
+		recipients := metadata[0].Value.([]yaml.MapSlice)
+		Expect(recipients).To(HaveLen(1))
+		Expect(recipients[0][0].Value).To(Equal(identity.Recipient().String()))
+		Expect(recipients[0][1].Value).To(HavePrefix("-----BEGIN AGE ENCRYPTED FILE-----"))
+		Expect(metadata[1].Key).To(Equal("lastmodified"))
+		Expect(metadata[2].Key).To(Equal("mac"))
+		Expect(metadata[3:]).To(Equal(yaml.MapSlice{
+			{Key: "encrypted_regex", Value: "^(data|stringData)$"},
+			{Key: "mac_only_encrypted", Value: true},
+			{Key: "version", Value: "3.9.0"},
+		}))
+
+		Expect(sopsDecrypt(document, identity)).To(Equal(yaml.MapSlice{
+			{Key: "apiVersion", Value: "v1"},
+			{Key: "kind", Value: "Secret"},
+			{Key: "metadata", Value: yaml.MapSlice{{Key: "name", Value: "my-secret"}}},
+			{Key: "data", Value: yaml.MapSlice{{Key: "API_ENDPOINT", Value: "aHR0cHM6Ly9hcGkubWV0cm8uZGlnaXRhbA=="}}},
+		}))
+	})
+
+	It("should still decrypt after Kustomize transformers changed the metadata", func() {
+		encryptedSecret := newSOPSPlugin()
+
+		document, err := GetSOPSSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		// namePrefix, namespace and commonLabels, with the keys sorted like Kustomize writes them
+		for i, item := range document {
+			if item.Key == "metadata" {
+				document[i].Value = yaml.MapSlice{
+					{Key: "labels", Value: yaml.MapSlice{{Key: "app", Value: "shop"}}},
+					{Key: "name", Value: "prod-my-secret"},
+					{Key: "namespace", Value: "shop"},
+				}
+			}
+		}
+		sort.SliceStable(document, func(i, j int) bool {
+			return fmt.Sprint(document[i].Key) < fmt.Sprint(document[j].Key)
+		})
+
+		decrypted := sopsDecrypt(document, identity)
+		Expect(sopsBranch(decrypted, "data")).To(Equal(yaml.MapSlice{{Key: "API_ENDPOINT", Value: "aHR0cHM6Ly9hcGkubWV0cm8uZGlnaXRhbA=="}}))
+	})
+
+	It("should be decrypted by sops after Kustomize transformers changed the metadata", func() {
+		if _, err := exec.LookPath("sops"); err != nil {
+			Skip("sops is not installed")
+		}
+		dir, err := ioutil.TempDir("", "sops")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		encryptedSecret := newSOPSPlugin()
+
+		document, err := GetSOPSSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		for i, item := range document {
+			if item.Key == "metadata" {
+				document[i].Value = yaml.MapSlice{{Key: "name", Value: "prod-my-secret"}, {Key: "namespace", Value: "shop"}}
+			}
+		}
+		content, err := yaml.Marshal(document)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "secret.yaml"), content, 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "age-key.txt"), []byte(identity.String()+"\n"), 0600)).To(Succeed())
+
+		command := exec.Command("sops", "--decrypt", filepath.Join(dir, "secret.yaml"))
+		command.Env = append(os.Environ(), "SOPS_AGE_KEY_FILE="+filepath.Join(dir, "age-key.txt"))
+		output, err := command.CombinedOutput()
+		Expect(err).ToNot(HaveOccurred(), string(output))
+		var decrypted yaml.MapSlice
+		Expect(yaml.Unmarshal(output, &decrypted)).To(Succeed())
+		Expect(sopsBranch(decrypted, "data")).To(Equal(yaml.MapSlice{{Key: "API_ENDPOINT", Value: "aHR0cHM6Ly9hcGkubWV0cm8uZGlnaXRhbA=="}}))
+	})
+
+	It("should keep metadata which is not a string", func() {
+		encryptedSecret := newSOPSPlugin()
+		controller, blockOwnerDeletion := true, false
+		encryptedSecret.OwnerReferences = []OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "shop",
+			Controller: &controller, BlockOwnerDeletion: &blockOwnerDeletion}}
+
+		document, err := GetSOPSSecret(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		metadata := sopsBranch(sopsDecrypt(document, identity), "metadata")
+		Expect(metadata).To(ContainElement(yaml.MapItem{Key: "ownerReferences", Value: []interface{}{yaml.MapSlice{
+			{Key: "apiVersion", Value: "apps/v1"}, {Key: "kind", Value: "Deployment"}, {Key: "name", Value: "shop"},
+			{Key: "uid", Value: ""}, {Key: "controller", Value: true}, {Key: "blockOwnerDeletion", Value: false},
+		}}}))
+	})
+})
@@ -0,0 +1,44 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("when creating a Kubernetes secret with stringData", func() {
+	encryptedSecret := createEncryptedGCPSecret("my-secret", "API_ENDPOINT")
+	encryptedSecret.StringData = true
+	encryptedSecret.Keys = []string{"API_ENDPOINT", "FEATURES", "LOGO"}
+
+	It("should write text values to stringData and keep binary values in data", func() {
+		expected := createExpectedK8SSecret("my-secret", "LOGO", "iVBORw0KGgo=")
+		expected.StringData = map[string]string{
+			"API_ENDPOINT": "https://api.metro.digital",
+			"FEATURES":     "search,checkout",
+		}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getConfigTestKeys, getConfigTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expected))
+	})
+})
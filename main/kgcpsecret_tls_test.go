@@ -0,0 +1,199 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+// createTestCertificate creates a PEM encoded certificate and private key, signed by the parent if given
+func createTestCertificate(name string, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, privateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &privateKey.PublicKey, parentKey)
+	Expect(err).ToNot(HaveOccurred())
+	certificate, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(privateKey)
+	Expect(err).ToNot(HaveOccurred())
+	return certificate, privateKey,
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+var _ = Describe("when creating a kubernetes.io/tls secret", func() {
+	var intermediatePEM, leafPEM, leafKeyPEM, otherKeyPEM string
+	var values map[string]string
+
+	getTLSTestValue := func(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+		if value, ok := values[key]; ok {
+			return value, nil
+		}
+		return "", errors.New("no value found for key")
+	}
+	getTLSTestKeys := func(project_id string) ([]string, error) {
+		keys := []string{}
+		for k := range values {
+			keys = append(keys, k)
+		}
+		return keys, nil
+	}
+	newTLSPlugin := func() KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("shop-tls", "")
+		encryptedSecret.Type = "kubernetes.io/tls"
+		encryptedSecret.Keys = nil
+		encryptedSecret.TLS = TLSOptions{CertificateKey: "SHOP_CERT", ChainKey: "SHOP_CHAIN", PrivateKeyKey: "SHOP_KEY"}
+		return encryptedSecret
+	}
+
+	BeforeEach(func() {
+		intermediate, intermediateKey, intermediateCert, _ := createTestCertificate("intermediate", time.Now().Add(365*24*time.Hour), nil, nil)
+		intermediatePEM = intermediateCert
+		_, _, leafPEM, leafKeyPEM = createTestCertificate("shop.metro.digital", time.Now().Add(10*24*time.Hour), intermediate, intermediateKey)
+		_, _, _, otherKeyPEM = createTestCertificate("other", time.Now().Add(time.Hour), nil, nil)
+		values = encodeTestValues(map[string]string{
+			"SHOP_CERT":  leafPEM,
+			"SHOP_CHAIN": intermediatePEM,
+			"SHOP_KEY":   leafKeyPEM,
+			"OTHER_KEY":  otherKeyPEM,
+			"NOT_PEM":    "not a certificate",
+		})
+	})
+
+	It("should assemble tls.crt from certificate and chain and tls.key from the private key", func() {
+		encryptedSecret := newTLSPlugin()
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTLSTestKeys, getTLSTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(encodeTestValues(map[string]string{
+			"tls.crt": leafPEM + intermediatePEM,
+			"tls.key": leafKeyPEM,
+		})))
+	})
+
+	It("should fail when the private key does not match the certificate", func() {
+		encryptedSecret := newTLSPlugin()
+		encryptedSecret.TLS.PrivateKeyKey = "OTHER_KEY"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTLSTestKeys, getTLSTestValue)
+		Expect(err).To(MatchError("tls.crt and tls.key are not a valid key pair: tls: private key does not match public key"))
+	})
+
+	It("should still check a complete key pair when merging", func() {
+		encryptedSecret := newTLSPlugin()
+		encryptedSecret.Behavior = "merge"
+		encryptedSecret.TLS.PrivateKeyKey = "OTHER_KEY"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTLSTestKeys, getTLSTestValue)
+		Expect(err).To(MatchError(ContainSubstring("tls.crt and tls.key are not a valid key pair")))
+	})
+
+	It("should fail when tls.crt is not PEM encoded", func() {
+		encryptedSecret := newTLSPlugin()
+		encryptedSecret.TLS = TLSOptions{}
+		encryptedSecret.Keys = []string{"tls.crt", "tls.key", "NOT_PEM", "SHOP_KEY"}
+		encryptedSecret.KeyOptions = map[string]KeyOptions{
+			"tls.crt": {DefaultFrom: "NOT_PEM"},
+			"tls.key": {DefaultFrom: "SHOP_KEY"},
+		}
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTLSTestKeys, getTLSTestValue)
+		Expect(err).To(MatchError(ContainSubstring("tls.crt and tls.key are not a valid key pair")))
+	})
+
+	It("should fail when tls.key is missing", func() {
+		encryptedSecret := newTLSPlugin()
+		encryptedSecret.TLS = TLSOptions{}
+		encryptedSecret.Keys = []string{"tls.crt", "SHOP_CERT"}
+		encryptedSecret.KeyOptions = map[string]KeyOptions{"tls.crt": {DefaultFrom: "SHOP_CERT"}}
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTLSTestKeys, getTLSTestValue)
+		Expect(err).To(MatchError("a secret of type kubernetes.io/tls needs the key tls.key: key 'tls.key' has no value"))
+	})
+
+	It("should warn when the certificate expires within the expiry window", func() {
+		encryptedSecret := newTLSPlugin()
+		encryptedSecret.TLS.ExpiryWindow = "720h"
+		warnings := &bytes.Buffer{}
+		SetWarnings(&encryptedSecret, warnings)
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTLSTestKeys, getTLSTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(warnings.String()).To(HavePrefix("Warning: the certificate in tls.crt of secret 'shop-tls' expires at "))
+		Expect(warnings.String()).To(HaveSuffix(", within the expiry window of 720h\n"))
+	})
+
+	It("should fail when the certificate expires within the expiry window and onExpiry is fail", func() {
+		encryptedSecret := newTLSPlugin()
+		encryptedSecret.TLS.ExpiryWindow = "720h"
+		encryptedSecret.TLS.OnExpiry = "fail"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTLSTestKeys, getTLSTestValue)
+		Expect(err).To(MatchError(ContainSubstring("within the expiry window of 720h")))
+	})
+
+	It("should fail for an expired certificate without an expiry window", func() {
+		_, _, expiredPEM, expiredKeyPEM := createTestCertificate("shop.metro.digital", time.Now().Add(-time.Minute), nil, nil)
+		values["SHOP_CERT"] = base64.StdEncoding.EncodeToString([]byte(expiredPEM))
+		values["SHOP_KEY"] = base64.StdEncoding.EncodeToString([]byte(expiredKeyPEM))
+		encryptedSecret := newTLSPlugin()
+		encryptedSecret.TLS.ChainKey = ""
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTLSTestKeys, getTLSTestValue)
+		Expect(err).To(MatchError(HavePrefix("the certificate in tls.crt of secret 'shop-tls' expired at ")))
+	})
+
+	It("should accept a certificate valid beyond the expiry window", func() {
+		encryptedSecret := newTLSPlugin()
+		encryptedSecret.TLS.ExpiryWindow = "24h"
+		encryptedSecret.TLS.OnExpiry = "fail"
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTLSTestKeys, getTLSTestValue)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,122 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+func gzipped(value string) string {
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	_, _ = w.Write([]byte(value))
+	_ = w.Close()
+	return out.String()
+}
+
+// payloads as stored in Google Secret Manager
+var transform_values = encodeTestValues(map[string]string{
+	"API_TOKEN":   "token-from-data-file\n",
+	"ENCODED_KEY": base64.StdEncoding.EncodeToString([]byte("already encoded")) + "\n",
+	"CONFIG":      gzipped("line 1\r\nline 2\r\n"),
+	"REGION":      "  europe-west1 ",
+})
+
+func getTransformTestValue(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+	if value, ok := transform_values[key]; ok {
+		return value, nil
+	}
+	return "", errors.New("no value found for key")
+}
+
+func getTransformTestKeys(project_id string) ([]string, error) {
+	keys := []string{}
+	for k := range transform_values {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+var _ = Describe("when transforming values", func() {
+	newTransformPlugin := func(options map[string]KeyOptions) KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("my-secret", "")
+		encryptedSecret.Keys = []string{"API_TOKEN", "ENCODED_KEY", "CONFIG", "REGION"}
+		encryptedSecret.KeyOptions = options
+		return encryptedSecret
+	}
+
+	It("should apply the transforms of each key in order", func() {
+		encryptedSecret := newTransformPlugin(map[string]KeyOptions{
+			"API_TOKEN":   {Transforms: []string{"trimTrailingNewline"}},
+			"ENCODED_KEY": {Transforms: []string{"base64Decode"}},
+			"CONFIG":      {Transforms: []string{"gunzip", "crlfToLf"}},
+			"REGION":      {Transforms: []string{"trimSpace", "toUpper"}},
+		})
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTransformTestKeys, getTransformTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(encodeTestValues(map[string]string{
+			"API_TOKEN":   "token-from-data-file",
+			"ENCODED_KEY": "already encoded",
+			"CONFIG":      "line 1\nline 2\n",
+			"REGION":      "EUROPE-WEST1",
+		})))
+	})
+
+	It("should keep values without transforms verbatim", func() {
+		encryptedSecret := newTransformPlugin(nil)
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTransformTestKeys, getTransformTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Data).To(BeEquivalentTo(transform_values))
+	})
+
+	It("should attribute a failing transform to the key", func() {
+		encryptedSecret := newTransformPlugin(map[string]KeyOptions{
+			"REGION": {Transforms: []string{"gunzip"}},
+		})
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTransformTestKeys, getTransformTestValue)
+		Expect(err).To(MatchError("error getting 'REGION' secret in Google project 'cf-2tier-uhd-test-d7'. transform gunzip failed: gzip: invalid header"))
+	})
+
+	It("should reject transforms for outputs referencing the secrets", func() {
+		encryptedSecret := newTransformPlugin(map[string]KeyOptions{
+			"API_TOKEN": {Transforms: []string{"trimTrailingNewline"}},
+		})
+		Expect(ValidateTransforms(&encryptedSecret, "API_TOKEN")).To(Succeed())
+
+		encryptedSecret.Output = "externalsecret"
+		Expect(ValidateTransforms(&encryptedSecret, "API_TOKEN")).To(MatchError("transforms cannot be used with output 'externalsecret'"))
+
+		encryptedSecret.Output = "secretproviderclass"
+		Expect(ValidateTransforms(&encryptedSecret, "API_TOKEN")).To(MatchError("transforms cannot be used with output 'secretproviderclass'"))
+	})
+})
@@ -0,0 +1,157 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+)
+
+var _ = Describe("when inferring the type of a secret", func() {
+	var values map[string]string
+	var warnings *bytes.Buffer
+
+	getTypesTestValue := func(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+		if value, ok := values[key]; ok {
+			return value, nil
+		}
+		return "", errors.New("no value found for key")
+	}
+	getTypesTestKeys := func(project_id string) ([]string, error) {
+		keys := []string{}
+		for k := range values {
+			keys = append(keys, k)
+		}
+		return keys, nil
+	}
+	newTypesPlugin := func(secretType string) KGCPSecret {
+		encryptedSecret := createEncryptedGCPSecret("typed-secret", "")
+		encryptedSecret.Type = secretType
+		encryptedSecret.Keys = nil
+		SetWarnings(&encryptedSecret, warnings)
+		return encryptedSecret
+	}
+
+	BeforeEach(func() {
+		_, _, certificatePEM, keyPEM := createTestCertificate("shop.metro.digital", time.Now().Add(365*24*time.Hour), nil, nil)
+		values = encodeTestValues(map[string]string{
+			"SHOP_CERT": certificatePEM,
+			"SHOP_KEY":  keyPEM,
+			"tls.crt":   certificatePEM,
+			"tls.key":   keyPEM,
+			"USER":      "admin",
+			"PASSWORD":  "s3cr3t",
+			"username":  "admin",
+			"password":  "s3cr3t",
+		})
+		warnings = &bytes.Buffer{}
+	})
+
+	It("should infer kubernetes.io/tls from the certificate and key", func() {
+		encryptedSecret := newTypesPlugin("auto")
+		encryptedSecret.TLS = TLSOptions{CertificateKey: "SHOP_CERT", PrivateKeyKey: "SHOP_KEY"}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTypesTestKeys, getTypesTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Type).To(Equal("kubernetes.io/tls"))
+		Expect(warnings.String()).To(BeEmpty())
+	})
+
+	It("should infer kubernetes.io/basic-auth from username and password", func() {
+		encryptedSecret := newTypesPlugin("auto")
+		encryptedSecret.BasicAuth = BasicAuthOptions{UsernameKey: "USER", PasswordKey: "PASSWORD"}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTypesTestKeys, getTypesTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Type).To(Equal("kubernetes.io/basic-auth"))
+	})
+
+	It("should infer kubernetes.io/basic-auth only with both username and password", func() {
+		encryptedSecret := newTypesPlugin("auto")
+		encryptedSecret.Keys = []string{"username", "password"}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTypesTestKeys, getTypesTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Type).To(Equal("kubernetes.io/basic-auth"))
+
+		encryptedSecret = newTypesPlugin("auto")
+		encryptedSecret.Keys = []string{"username"}
+
+		actual, err = GetSecrets(ctx, nil, &encryptedSecret, getTypesTestKeys, getTypesTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Type).To(Equal("Opaque"))
+	})
+
+	It("should fall back to Opaque for other data", func() {
+		encryptedSecret := newTypesPlugin("auto")
+		encryptedSecret.Keys = []string{"USER", "SHOP_CERT"}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTypesTestKeys, getTypesTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Type).To(Equal("Opaque"))
+	})
+
+	It("should warn about a type that differs from a built-in type in case only", func() {
+		encryptedSecret := newTypesPlugin("opaque")
+		encryptedSecret.Keys = []string{"USER"}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTypesTestKeys, getTypesTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Type).To(Equal("opaque"))
+		Expect(warnings.String()).To(ContainSubstring("type 'opaque' is not the built-in type 'Opaque'"))
+	})
+
+	It("should warn when the type disagrees with the data", func() {
+		encryptedSecret := newTypesPlugin("Opaque")
+		encryptedSecret.Keys = []string{"tls.crt", "tls.key"}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTypesTestKeys, getTypesTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Type).To(Equal("Opaque"))
+		Expect(warnings.String()).To(ContainSubstring("looks like type 'kubernetes.io/tls'"))
+	})
+
+	It("should not warn when the type matches the data", func() {
+		encryptedSecret := newTypesPlugin("kubernetes.io/basic-auth")
+		encryptedSecret.Keys = []string{"username", "password"}
+
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getTypesTestKeys, getTypesTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(warnings.String()).To(BeEmpty())
+	})
+
+	It("should not warn when the type is not set", func() {
+		encryptedSecret := newTypesPlugin("")
+		encryptedSecret.Keys = []string{"tls.crt", "tls.key"}
+
+		actual, err := GetSecrets(ctx, nil, &encryptedSecret, getTypesTestKeys, getTypesTestValue)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Type).To(BeEmpty())
+		Expect(warnings.String()).To(BeEmpty())
+	})
+})
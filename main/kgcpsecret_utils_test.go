@@ -19,6 +19,7 @@ package main_test
 
 import (
 	"context"
+	"encoding/base64"
 
 	. "github.com/metro-digital/kustomize-google-secret-manager/main"
 )
@@ -67,3 +68,12 @@ func createExpectedK8SSecret(name string, secretKey string, secretValue string)
 		Type: "",
 	}
 }
+
+// encodeTestValues base64 encodes the values like Google Secret Manager returns them
+func encodeTestValues(values map[string]string) map[string]string {
+	encoded := make(map[string]string)
+	for key, value := range values {
+		encoded[key] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	return encoded
+}
@@ -0,0 +1,183 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//go:build unitTests
+// +build unitTests
+
+package main_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/metro-digital/kustomize-google-secret-manager/main"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func createSecretVersion(id string, state secretmanagerpb.SecretVersion_State, created string) *secretmanagerpb.SecretVersion {
+	createTime, _ := time.Parse(time.RFC3339, created)
+	return &secretmanagerpb.SecretVersion{
+		Name:       "projects/cf-2tier-uhd-test-d7/secrets/db-password/versions/" + id,
+		State:      state,
+		CreateTime: timestamppb.New(createTime),
+	}
+}
+
+func destroySecretVersion(version *secretmanagerpb.SecretVersion, destroyed string) *secretmanagerpb.SecretVersion {
+	destroyTime, _ := time.Parse(time.RFC3339, destroyed)
+	version.DestroyTime = timestamppb.New(destroyTime)
+	return version
+}
+
+var secret_versions = []*secretmanagerpb.SecretVersion{
+	createSecretVersion("1", secretmanagerpb.SecretVersion_ENABLED, "2021-06-01T10:00:00Z"),
+	createSecretVersion("2", secretmanagerpb.SecretVersion_DISABLED, "2021-06-08T10:00:00Z"),
+	createSecretVersion("3", secretmanagerpb.SecretVersion_ENABLED, "2021-06-15T10:00:00Z"),
+	destroySecretVersion(createSecretVersion("4", secretmanagerpb.SecretVersion_DESTROYED, "2021-06-22T10:00:00Z"),
+		"2021-06-25T10:00:00Z"),
+}
+
+var _ = Describe("when selecting the secret version for a point in time", func() {
+
+	It("should use the newest enabled version created before that time", func() {
+		asOf, _ := time.Parse(time.RFC3339, "2021-06-30T00:00:00Z")
+		Expect(SelectSecretVersion(secret_versions, asOf).Name).To(HaveSuffix("/versions/3"))
+
+		asOf, _ = time.Parse(time.RFC3339, "2021-06-15T09:59:59Z")
+		Expect(SelectSecretVersion(secret_versions, asOf).Name).To(HaveSuffix("/versions/1"))
+	})
+
+	It("should find nothing if no version existed at that time", func() {
+		asOf, _ := time.Parse(time.RFC3339, "2021-05-01T00:00:00Z")
+		Expect(SelectSecretVersion(secret_versions, asOf)).To(BeNil())
+	})
+
+	It("should skip versions which are not enabled", func() {
+		parent := "projects/cf-2tier-uhd-test-d7/secrets/db-password"
+		asOf, _ := time.Parse(time.RFC3339, "2021-06-10T00:00:00Z")
+		Expect(SecretVersionAt(parent, secret_versions, asOf, true, false)).To(Equal("1"))
+
+		// version 4 was already destroyed at that time
+		asOf, _ = time.Parse(time.RFC3339, "2021-06-30T00:00:00Z")
+		Expect(SecretVersionAt(parent, secret_versions, asOf, true, false)).To(Equal("3"))
+	})
+
+	It("should fail if the version of that time was destroyed since", func() {
+		parent := "projects/cf-2tier-uhd-test-d7/secrets/db-password"
+		asOf, _ := time.Parse(time.RFC3339, "2021-06-23T00:00:00Z")
+		_, err := SecretVersionAt(parent, secret_versions, asOf, true, false)
+		Expect(err).To(MatchError("secret " + parent + " has version 4 as the newest version created before " +
+			"2021-06-23T00:00:00Z, but it was destroyed at 2021-06-25T10:00:00Z (set fallbackToEnabledVersion to use version 3)"))
+
+		Expect(SecretVersionAt(parent, secret_versions, asOf, true, true)).To(Equal("3"))
+		Expect(SecretVersionAt(parent, secret_versions, asOf, false, false)).To(Equal("3"))
+	})
+})
+
+var _ = Describe("when a minimum version age is configured", func() {
+	encryptedSecret := createEncryptedGCPSecret("my-secret", "db-password")
+	encryptedSecret.AsOf = "2021-06-30T00:00:00Z"
+	encryptedSecret.MinVersionAge = "24h"
+	encryptedSecret.KeyOptions = map[string]KeyOptions{
+		"db-password": {MinVersionAge: "360h"},
+	}
+
+	It("should only use versions older than the minimum age", func() {
+		cutoff, err := VersionCutoff(&encryptedSecret, "db-user")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cutoff.Format(time.RFC3339)).To(Equal("2021-06-29T00:00:00Z"))
+	})
+
+	It("should prefer the minimum age of the key", func() {
+		cutoff, err := VersionCutoff(&encryptedSecret, "db-password")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cutoff.Format(time.RFC3339)).To(Equal("2021-06-15T00:00:00Z"))
+		Expect(SelectSecretVersion(secret_versions, cutoff).Name).To(HaveSuffix("/versions/1"))
+	})
+
+	It("should count the minimum age from now without a point in time", func() {
+		withoutAsOf := encryptedSecret
+		withoutAsOf.AsOf = ""
+		cutoff, err := VersionCutoff(&withoutAsOf, "db-user")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cutoff).To(BeTemporally("~", time.Now().Add(-24*time.Hour), time.Minute))
+	})
+
+	It("should reject invalid durations", func() {
+		invalid := encryptedSecret
+		invalid.MinVersionAge = "one day"
+		_, err := VersionCutoff(&invalid, "db-user")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("minVersionAge must be a positive duration like '24h', got 'one day'"))
+	})
+})
+
+func getVersionStateTestValue(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+	if key == "my-secret_db-password" {
+		return "", NewVersionStateError("projects/cf-2tier-uhd-test-d7/secrets/my-secret_db-password", "has no enabled versions")
+	}
+	if key == "db-password" {
+		return "generic-db-password", nil
+	}
+	return "", errors.New("no value found for key")
+}
+
+func getVersionStateTestKeys(project_id string) ([]string, error) {
+	return []string{"my-secret_db-password", "db-password"}, nil
+}
+
+var _ = Describe("when the most specific secret has no enabled version", func() {
+	encryptedSecret := createEncryptedGCPSecret("my-secret", "db-password")
+
+	It("should not fall back to a less specific secret", func() {
+		expected := "error getting 'db-password' secret in Google project 'cf-2tier-uhd-test-d7'. " +
+			"secret projects/cf-2tier-uhd-test-d7/secrets/my-secret_db-password has no enabled versions"
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getVersionStateTestKeys, getVersionStateTestValue)
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(expected))
+	})
+})
+
+var _ = Describe("when the most specific secret has no version old enough", func() {
+	encryptedSecret := createEncryptedGCPSecret("my-secret", "db-password")
+
+	getYoungVersionTestValue := func(ctx context.Context, client *secretmanager.Client, plugin *KGCPSecret, key string) (string, error) {
+		if key == "my-secret_db-password" {
+			cutoff, _ := time.Parse(time.RFC3339, "2021-05-01T00:00:00Z")
+			return SecretVersionAt("projects/cf-2tier-uhd-test-d7/secrets/my-secret_db-password", secret_versions, cutoff, false, false)
+		}
+		if key == "db-password" {
+			return "generic-db-password", nil
+		}
+		return "", errors.New("no value found for key")
+	}
+
+	It("should not fall back to a less specific secret", func() {
+		expected := "error getting 'db-password' secret in Google project 'cf-2tier-uhd-test-d7'. " +
+			"secret projects/cf-2tier-uhd-test-d7/secrets/my-secret_db-password has no enabled version created before 2021-05-01T00:00:00Z"
+		_, err := GetSecrets(ctx, nil, &encryptedSecret, getVersionStateTestKeys, getYoungVersionTestValue)
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(expected))
+	})
+})
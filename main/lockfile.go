@@ -0,0 +1,127 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// lockfile records the version and the CRC32C checksum read for every secret, so later runs read the same
+// versions and detect payloads which differ from the ones read before. Outputs which only reference the secrets
+// record the versions without a checksum.
+// All methods can be called on a nil lockfile, which records nothing.
+type lockfile struct {
+	// Secrets are the locked versions by the name of their secret, e.g. projects/p/secrets/s
+	Secrets map[string]lockedVersion `json:"secrets" yaml:"secrets"`
+}
+
+type lockedVersion struct {
+	Version string `json:"version" yaml:"version"`
+	Crc32C  *int64 `json:"crc32c,omitempty" yaml:"crc32c,omitempty"`
+}
+
+// readLockfile reads a lockfile, or returns an empty one if the file does not exist yet
+func readLockfile(fn string) (*lockfile, error) {
+	lock := &lockfile{Secrets: make(map[string]lockedVersion)}
+	content, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(content, lock); err != nil {
+		return nil, errors.Wrapf(err, "invalid lockfile %s", fn)
+	}
+	if lock.Secrets == nil {
+		lock.Secrets = make(map[string]lockedVersion)
+	}
+	return lock, nil
+}
+
+// write writes the lockfile, keeping the secrets of other KGCPSecrets using the same file
+func (l *lockfile) write(fn string) error {
+	if l == nil {
+		return nil
+	}
+	content, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, content, 0644)
+}
+
+// lockedVersion returns the version id locked for a secret
+func (l *lockfile) lockedVersion(parent string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	locked, ok := l.Secrets[parent]
+	return locked.Version, ok
+}
+
+// record locks the version read for a secret. A payload of a locked version which does not match the checksum
+// read before is corrupted, as versions never change. A version read without its payload keeps the checksum
+// recorded before.
+func (l *lockfile) record(parent string, secret *secretmanagerpb.AccessSecretVersionResponse) error {
+	if l == nil {
+		return nil
+	}
+	name := secret.GetName()
+	version := name[strings.LastIndex(name, "/")+1:]
+	locked, ok := l.Secrets[parent]
+	if ok && locked.Version != version {
+		locked = lockedVersion{}
+	}
+	locked.Version = version
+	if secret.GetPayload() == nil {
+		l.Secrets[parent] = locked
+		return nil
+	}
+	checksum := int64(crc32.Checksum(secret.GetPayload().GetData(), crc32cTable))
+	if locked.Crc32C != nil && *locked.Crc32C != checksum {
+		return &checksumError{name: name, expected: *locked.Crc32C, actual: uint32(checksum)}
+	}
+	locked.Crc32C = &checksum
+	l.Secrets[parent] = locked
+	return nil
+}
+
+// lockedVersionError reports a locked version which cannot be read anymore. It stops the lookup, as a less specific
+// secret must not replace the locked one.
+func lockedVersionError(parent string, version string, err error) error {
+	switch status.Code(err) {
+	case codes.FailedPrecondition:
+		return &versionStateError{secret: parent,
+			message: fmt.Sprintf("has version %s locked in the lockfile, but it is disabled or destroyed", version)}
+	case codes.NotFound:
+		return &versionStateError{secret: parent,
+			message: fmt.Sprintf("has version %s locked in the lockfile, but it does not exist anymore", version)}
+	}
+	return errors.Wrapf(err, "trouble retrieving secret: %s/versions/%s", parent, version)
+}
@@ -0,0 +1,119 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// requireMatch policies, each forbids the use of secrets less specific than its level
+const (
+	requireMatchAny            = "any"
+	requireMatchEnvironment    = "environment"
+	requireMatchEnvironmentTag = "environment+tag"
+	requireMatchExact          = "exact"
+)
+
+// lookupCandidate is the name of a secret that can hold the value of a key
+type lookupCandidate struct {
+	name string
+	// the secret name is prefixed with the namespace and name of the KGCPSecret
+	namespaceAndName bool
+	// the secret name is prefixed with the name of the KGCPSecret only
+	nameOnly    bool
+	environment bool
+	tag         bool
+}
+
+// lookupCandidates returns the names of the secrets that can hold the value of a key,
+// from the most to the least specific one
+func lookupCandidates(plugin *KGCPSecret, key string) []lookupCandidate {
+	environment, tag := plugin.environment(), plugin.tag()
+	prefixes := []string{
+		plugin.Namespace + "_" + plugin.Name + "_",
+		plugin.Name + "_",
+		plugin.Namespace + "_",
+		"",
+	}
+	postfixes := []string{
+		"_" + environment + "_" + tag,
+		"_" + environment,
+		"_" + tag,
+		"",
+	}
+	candidates := []lookupCandidate{}
+	for i, prefix := range prefixes {
+		for j, postfix := range postfixes {
+			candidates = append(candidates, lookupCandidate{
+				name:             prefix + key + postfix,
+				namespaceAndName: i == 0,
+				nameOnly:         i == 1,
+				environment:      j <= 1,
+				tag:              j == 0 || j == 2,
+			})
+		}
+	}
+	return candidates
+}
+
+func (p *KGCPSecret) environment() string {
+	if p.Environment != "" {
+		return p.Environment
+	}
+	return p.Stage
+}
+
+func (p *KGCPSecret) tag() string {
+	if p.Tag != "" {
+		return p.Tag
+	}
+	return p.Dc
+}
+
+// allowsMatch checks whether the requireMatch policy allows to use the candidate
+func (p *KGCPSecret) allowsMatch(candidate lookupCandidate) bool {
+	switch p.RequireMatch {
+	case requireMatchEnvironment:
+		return candidate.environment
+	case requireMatchEnvironmentTag:
+		return candidate.environment && candidate.tag
+	case requireMatchExact:
+		prefixed := candidate.namespaceAndName || (candidate.nameOnly && p.Namespace == "")
+		return prefixed && candidate.environment && candidate.tag
+	}
+	return true
+}
+
+// validateRequireMatch checks that the requireMatch policy is known and can be fulfilled
+func (p *KGCPSecret) validateRequireMatch() error {
+	switch p.RequireMatch {
+	case "", requireMatchAny:
+		return nil
+	case requireMatchEnvironment:
+		if p.environment() == "" {
+			return errors.New("requireMatch 'environment' needs metadata.environment")
+		}
+	case requireMatchEnvironmentTag, requireMatchExact:
+		if p.environment() == "" || p.tag() == "" {
+			return errors.Errorf("requireMatch '%s' needs metadata.environment and metadata.tag", p.RequireMatch)
+		}
+	default:
+		return errors.Errorf("requireMatch must be one of '%s', '%s', '%s' or '%s', got '%s'", requireMatchAny,
+			requireMatchEnvironment, requireMatchEnvironmentTag, requireMatchExact, p.RequireMatch)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// metadataMap holds labels or annotations. It is written as a map, as a list of single entry maps or as a list of
+// key=value strings.
+type metadataMap map[string]string
+
+// UnmarshalYAML normalizes the forms of labels and annotations into a map and rejects duplicate keys
+func (m *metadataMap) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw interface{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	var entries yaml.MapSlice
+	switch list := raw.(type) {
+	case nil:
+	case map[interface{}]interface{}:
+		if err := unmarshal(&entries); err != nil {
+			return err
+		}
+	case []interface{}:
+		for _, item := range list {
+			itemEntries, err := metadataListEntries(item)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, itemEntries...)
+		}
+	default:
+		return errors.New("labels and annotations must be a map or a list")
+	}
+
+	result := make(metadataMap)
+	for _, entry := range entries {
+		key, ok := entry.Key.(string)
+		if !ok || key == "" {
+			return errors.Errorf("label or annotation key %v is not a string", entry.Key)
+		}
+		value, err := metadataValue(key, entry.Value)
+		if err != nil {
+			return err
+		}
+		if _, ok := result[key]; ok {
+			return errors.Errorf("label or annotation '%s' is set more than once", key)
+		}
+		result[key] = value
+	}
+	*m = result
+	return nil
+}
+
+// metadataListEntries returns the entries of a list item, either a single entry map or a key=value string
+func metadataListEntries(item interface{}) (yaml.MapSlice, error) {
+	switch item := item.(type) {
+	case string:
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("label or annotation '%s' must have the form key=value", item)
+		}
+		return yaml.MapSlice{{Key: strings.TrimSpace(parts[0]), Value: parts[1]}}, nil
+	case map[interface{}]interface{}:
+		if len(item) != 1 {
+			return nil, errors.Errorf("label or annotation list entries must have a single key, got %d", len(item))
+		}
+		for key, value := range item {
+			return yaml.MapSlice{{Key: key, Value: value}}, nil
+		}
+	}
+	return nil, errors.Errorf("label or annotation list entry %v must be a map or a key=value string", item)
+}
+
+// metadataValue converts a scalar value of a label or annotation into a string
+func metadataValue(key string, value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(value), nil
+	}
+	return "", errors.Errorf("label or annotation '%s' must have a string value", key)
+}
+
+// pluginObjectMeta returns the metadata of the generated resource as given in the KGCPSecret
+func pluginObjectMeta(plugin *KGCPSecret) ObjectMeta {
+	return ObjectMeta{
+		Name:            plugin.Name,
+		Namespace:       plugin.Namespace,
+		GenerateName:    plugin.GenerateName,
+		Labels:          kvMap(plugin.Labels),
+		Annotations:     kvMap(plugin.Annotations),
+		OwnerReferences: plugin.OwnerReferences,
+		Finalizers:      plugin.Finalizers,
+	}
+}
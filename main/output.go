@@ -0,0 +1,180 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"unicode/utf8"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+
+	"github.com/pkg/errors"
+)
+
+// kinds of the plugin input
+const (
+	kindSecret    = "KGCPSecret"
+	kindConfigMap = "KGCPConfigMap"
+)
+
+// kinds of resources the plugin can generate
+const (
+	outputSecret         = "secret"
+	outputConfigMap      = "configmap"
+	outputExternalSecret = "externalsecret"
+	outputSealedSecret   = "sealedsecret"
+	outputSOPS           = "sops"
+	outputSecretProvider = "secretproviderclass"
+)
+
+// output returns the kind of resource to generate for the KGCPSecret
+func (p *KGCPSecret) output() string {
+	if p.Output != "" {
+		return p.Output
+	}
+	if p.Kind == kindConfigMap {
+		return outputConfigMap
+	}
+	return outputSecret
+}
+
+func (p *KGCPSecret) validateOutput() error {
+	switch p.output() {
+	case outputSecret:
+		if p.Kind == kindConfigMap {
+			return errors.Errorf("output '%s' cannot be used with kind %s", p.Output, kindConfigMap)
+		}
+	case outputConfigMap:
+		if p.Type != "" || p.StringData {
+			return errors.Errorf("type and stringData cannot be used with output '%s'", outputConfigMap)
+		}
+	case outputExternalSecret:
+		if p.StringData {
+			return errors.Errorf("stringData cannot be used with output '%s'", outputExternalSecret)
+		}
+		if p.Type == typeAuto {
+			return errors.Errorf("type '%s' cannot be used with output '%s'", typeAuto, outputExternalSecret)
+		}
+		if p.ExternalSecret.SecretStoreRef.Name == "" {
+			return errors.Errorf("output '%s' needs externalSecret.secretStoreRef.name", outputExternalSecret)
+		}
+	case outputSealedSecret:
+		if p.StringData {
+			return errors.Errorf("stringData cannot be used with output '%s'", outputSealedSecret)
+		}
+		return p.validateSealedSecret()
+	case outputSOPS:
+		return p.validateSOPS()
+	case outputSecretProvider:
+		if p.StringData {
+			return errors.Errorf("stringData cannot be used with output '%s'", outputSecretProvider)
+		}
+		if p.Type == typeAuto {
+			return errors.Errorf("type '%s' cannot be used with output '%s'", typeAuto, outputSecretProvider)
+		}
+	default:
+		return errors.Errorf("output must be one of '%s', '%s', '%s', '%s', '%s' or '%s', got '%s'",
+			outputSecret, outputConfigMap, outputExternalSecret, outputSealedSecret, outputSOPS, outputSecretProvider, p.Output)
+	}
+	return nil
+}
+
+// readsPayloads tells whether the values of the secrets are read. The outputs which reference the secrets only need
+// the versions of the secrets, unless keyOptions validate their values.
+func (p *KGCPSecret) readsPayloads() bool {
+	switch p.output() {
+	case outputExternalSecret, outputSecretProvider:
+	default:
+		return true
+	}
+	for _, keyOptions := range p.KeyOptions {
+		if keyOptions.Validate != (ValueRules{}) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderOutput generates the resource chosen by the output of the KGCPSecret
+func renderOutput(ctx context.Context, client *secretmanager.Client,
+	plugin *KGCPSecret, listGCPSecrets secretsGetter, getGCPSecretValue secretValueGetter) (interface{}, error) {
+	switch plugin.output() {
+	case outputConfigMap:
+		return GetConfigMap(ctx, client, plugin, listGCPSecrets, getGCPSecretValue)
+	case outputExternalSecret:
+		return GetExternalSecret(ctx, client, plugin, listGCPSecrets, getGCPSecretValue)
+	case outputSealedSecret:
+		return GetSealedSecret(ctx, client, plugin, listGCPSecrets, getGCPSecretValue)
+	case outputSOPS:
+		return GetSOPSSecret(ctx, client, plugin, listGCPSecrets, getGCPSecretValue)
+	case outputSecretProvider:
+		return GetSecretProviderClass(ctx, client, plugin, listGCPSecrets, getGCPSecretValue)
+	}
+	return GetSecrets(ctx, client, plugin, listGCPSecrets, getGCPSecretValue)
+}
+
+// secretReference is the secret in Google Secret Manager the value of a key was taken from
+type secretReference struct {
+	key    string
+	secret string
+}
+
+// secretReferences returns the secrets of the keys in the order of the keys for outputs which reference the
+// secrets instead of containing their values. The secrets are taken from the explanation of the lookup.
+func secretReferences(plugin *KGCPSecret, values kvMap, referencedBy string) ([]secretReference, error) {
+	resolved := plugin.explanation
+	references := []secretReference{}
+	for _, key := range resolved.keys {
+		secret, ok := resolved.secrets[key]
+		if !ok && plugin.KeyOptions[key].DefaultFrom != "" {
+			secret, ok = resolved.secrets[plugin.KeyOptions[key].DefaultFrom]
+		}
+		if !ok {
+			if _, hasValue := values[key]; hasValue {
+				return nil, errors.Errorf("key '%s' has a default value, which cannot be referenced by %s", key, referencedBy)
+			}
+			// optional key without value
+			continue
+		}
+		references = append(references, secretReference{key: key, secret: secret})
+	}
+	return references, nil
+}
+
+// splitTextValues decodes the base64 encoded values that are valid UTF-8 text. Other values are returned
+// as they are in binary. Empty maps are returned as nil.
+func splitTextValues(values kvMap) (text kvMap, binary kvMap, err error) {
+	for key, value := range values {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "value of key '%s' is not base64 encoded", key)
+		}
+		if utf8.Valid(decoded) {
+			if text == nil {
+				text = make(kvMap)
+			}
+			text[key] = string(decoded)
+			continue
+		}
+		if binary == nil {
+			binary = make(kvMap)
+		}
+		binary[key] = value
+	}
+	return text, binary, nil
+}
@@ -0,0 +1,131 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// categories of the failures to resolve a key
+const (
+	categoryNotFound     = "not-found"
+	categoryAccessFailed = "access-failed"
+	categoryVersionState = "version-state"
+	categoryCorrupted    = "corrupted"
+	categoryUnavailable  = "unavailable"
+	categoryPolicy       = "policy"
+	categoryInvalidValue = "invalid-value"
+)
+
+// categorizedError is implemented by errors which belong to a specific failure category
+type categorizedError interface {
+	category() string
+}
+
+func errorCategory(err error) string {
+	var categorized categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.category()
+	}
+	return categoryAccessFailed
+}
+
+// keyError reports a key whose value could not be resolved
+type keyError struct {
+	Key        string   `json:"key"`
+	Project    string   `json:"project"`
+	Category   string   `json:"category"`
+	Candidates []string `json:"candidates"`
+	err        error
+}
+
+func (e *keyError) Error() string {
+	return fmt.Sprintf("error getting '%s' secret in Google project '%s'. %s", e.Key, e.Project, e.err)
+}
+
+func (e *keyError) Unwrap() error {
+	return e.err
+}
+
+// secretErrors collects the errors of all keys of a KGCPSecret that could not be resolved
+type secretErrors []*keyError
+
+func (e secretErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	messages := make([]string, len(e))
+	for i, keyErr := range e {
+		messages[i] = "- " + keyErr.Error()
+	}
+	return fmt.Sprintf("%s:\n%s", e.summary(), strings.Join(messages, "\n"))
+}
+
+// summary tells how many keys could not be resolved, for the error and the text report
+func (e secretErrors) summary() string {
+	if len(e) == 1 {
+		return "1 key could not be resolved"
+	}
+	return fmt.Sprintf("%d keys could not be resolved", len(e))
+}
+
+// errorReport is the machine-readable form of an error
+type errorReport struct {
+	Message string      `json:"message"`
+	Keys    []keyReport `json:"keys,omitempty"`
+}
+
+type keyReport struct {
+	*keyError
+	Message string `json:"message"`
+}
+
+// writeErrorReport writes an error for the user, listing the failing keys with the candidates that
+// were tried. The format is either "json" or "text" (the default).
+func writeErrorReport(w io.Writer, err error, format string) {
+	var keyErrors secretErrors
+	if !errors.As(err, &keyErrors) {
+		var keyErr *keyError
+		if errors.As(err, &keyErr) {
+			keyErrors = secretErrors{keyErr}
+		}
+	}
+
+	if format == "json" {
+		report := errorReport{Message: err.Error()}
+		for _, keyErr := range keyErrors {
+			report.Keys = append(report.Keys, keyReport{keyError: keyErr, Message: keyErr.err.Error()})
+		}
+		_ = json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	if len(keyErrors) == 0 {
+		_, _ = fmt.Fprintf(w, "Error: %v\n", err)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "Error: %s:\n", keyErrors.summary())
+	for _, keyErr := range keyErrors {
+		_, _ = fmt.Fprintf(w, "- %s (%s)\n", keyErr.Error(), keyErr.Category)
+		_, _ = fmt.Fprintf(w, "  tried: %s\n", strings.Join(keyErr.Candidates, ", "))
+	}
+}
@@ -0,0 +1,189 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how calls to Google Secret Manager are retried
+type RetryPolicy struct {
+	MaxAttempts    int    `json:"maxAttempts,omitempty" yaml:"maxAttempts,omitempty"`
+	InitialBackoff string `json:"initialBackoff,omitempty" yaml:"initialBackoff,omitempty"`
+	MaxBackoff     string `json:"maxBackoff,omitempty" yaml:"maxBackoff,omitempty"`
+	CallTimeout    string `json:"callTimeout,omitempty" yaml:"callTimeout,omitempty"`
+	Deadline       string `json:"deadline,omitempty" yaml:"deadline,omitempty"`
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: "500ms",
+	MaxBackoff:     "8s",
+	CallTimeout:    "30s",
+	Deadline:       "2m",
+}
+
+// retryableCodes are the gRPC status codes of errors which are worth another attempt
+var retryableCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.ResourceExhausted: true,
+	codes.DeadlineExceeded:  true,
+	codes.Aborted:           true,
+}
+
+// noClientRetry disables the retries built into the Secret Manager client, they are done by gcpCaller
+var noClientRetry = gax.WithRetry(nil)
+
+// gcpCaller runs the calls to Google Secret Manager with the retry policy and rate limit of a KGCPSecret
+type gcpCaller struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	callTimeout    time.Duration
+	deadline       time.Duration
+	limiter        *rateLimiter
+}
+
+// retriesExhaustedError reports a call which failed even after retrying it. It stops the lookup of
+// less specific secrets, as the failure says nothing about the existence of the secret.
+type retriesExhaustedError struct {
+	operation string
+	attempts  int
+	err       error
+}
+
+func (e *retriesExhaustedError) Error() string {
+	return fmt.Sprintf("giving up to %s after %d attempts: %v", e.operation, e.attempts, e.err)
+}
+
+func (e *retriesExhaustedError) Unwrap() error {
+	return e.err
+}
+
+func (e *retriesExhaustedError) stopsLookup() {}
+
+func (e *retriesExhaustedError) category() string {
+	return categoryUnavailable
+}
+
+func newGCPCaller(plugin *KGCPSecret) (*gcpCaller, error) {
+	policy := plugin.Retry
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = defaultRetryPolicy.MaxAttempts
+	}
+	if policy.MaxAttempts < 0 {
+		return nil, errors.Errorf("retry.maxAttempts must be positive, got %d", policy.MaxAttempts)
+	}
+	caller := &gcpCaller{maxAttempts: policy.MaxAttempts}
+	durations := []struct {
+		field  string
+		value  string
+		preset string
+		target *time.Duration
+	}{
+		{"initialBackoff", policy.InitialBackoff, defaultRetryPolicy.InitialBackoff, &caller.initialBackoff},
+		{"maxBackoff", policy.MaxBackoff, defaultRetryPolicy.MaxBackoff, &caller.maxBackoff},
+		{"callTimeout", policy.CallTimeout, defaultRetryPolicy.CallTimeout, &caller.callTimeout},
+		{"deadline", policy.Deadline, defaultRetryPolicy.Deadline, &caller.deadline},
+	}
+	for _, d := range durations {
+		value := d.value
+		if value == "" {
+			value = d.preset
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return nil, errors.Errorf("retry.%s must be a positive duration like '%s', got '%s'", d.field, d.preset, value)
+		}
+		*d.target = duration
+	}
+
+	if plugin.RequestsPerSecond < 0 {
+		return nil, errors.Errorf("requestsPerSecond must be positive, got %v", plugin.RequestsPerSecond)
+	}
+	if plugin.RequestsPerSecond > 0 {
+		caller.limiter = &rateLimiter{interval: time.Duration(float64(time.Second) / plugin.RequestsPerSecond)}
+	}
+	return caller, nil
+}
+
+// call runs f until it succeeds, fails with an error that is not retryable, or the attempts or the
+// deadline are used up. Each attempt gets its own timeout.
+func (c *gcpCaller) call(ctx context.Context, operation string, f func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, c.deadline)
+	defer cancel()
+
+	backoff := c.initialBackoff
+	for attempt := 1; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			return &retriesExhaustedError{operation: operation, attempts: attempt - 1, err: err}
+		}
+		callCtx, cancelCall := context.WithTimeout(ctx, c.callTimeout)
+		err := f(callCtx)
+		cancelCall()
+		if err == nil || !retryableCodes[status.Code(err)] {
+			return err
+		}
+		if attempt >= c.maxAttempts {
+			return &retriesExhaustedError{operation: operation, attempts: attempt, err: err}
+		}
+
+		// sleep between half and the full backoff, so that parallel builds do not retry in lockstep
+		sleep := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if sleepErr := gax.Sleep(ctx, sleep); sleepErr != nil {
+			return &retriesExhaustedError{operation: operation, attempts: attempt, err: err}
+		}
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// rateLimiter spaces out calls to stay below a number of requests per second.
+// A nil rateLimiter does not limit anything.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	return gax.Sleep(ctx, at.Sub(now))
+}
@@ -0,0 +1,169 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// formats a value can be validated against
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatPEM  = "pem"
+	formatURL  = "url"
+	formatUUID = "uuid"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ValueRules are constraints the value of a key must fulfill
+type ValueRules struct {
+	Regex                 string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	NotRegex              string `json:"notRegex,omitempty" yaml:"notRegex,omitempty"`
+	MinLength             int    `json:"minLength,omitempty" yaml:"minLength,omitempty"`
+	MaxLength             int    `json:"maxLength,omitempty" yaml:"maxLength,omitempty"`
+	Format                string `json:"format,omitempty" yaml:"format,omitempty"`
+	NotEqualToEnvironment string `json:"notEqualToEnvironment,omitempty" yaml:"notEqualToEnvironment,omitempty"`
+}
+
+// environmentValueGetter resolves the value a key has in another environment
+type environmentValueGetter func(environment string) (string, error)
+
+func (r ValueRules) validate() error {
+	for _, pattern := range []string{r.Regex, r.NotRegex} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.Wrapf(err, "invalid regex '%s'", pattern)
+		}
+	}
+	if r.MinLength < 0 || r.MaxLength < 0 || (r.MaxLength > 0 && r.MinLength > r.MaxLength) {
+		return errors.Errorf("minLength and maxLength must be positive and minLength must not be greater than maxLength")
+	}
+	switch r.Format {
+	case "", formatJSON, formatYAML, formatPEM, formatURL, formatUUID:
+	default:
+		return errors.Errorf("format must be one of '%s', '%s', '%s', '%s' or '%s', got '%s'",
+			formatJSON, formatYAML, formatPEM, formatURL, formatUUID, r.Format)
+	}
+	return nil
+}
+
+// validateValue checks the base64 encoded value of a key against its rules. The messages never contain the value.
+func validateValue(plugin *KGCPSecret, key string, value string, inEnvironment environmentValueGetter) *keyError {
+	rules := plugin.KeyOptions[key].Validate
+	if rules == (ValueRules{}) {
+		return nil
+	}
+	keyErr := &keyError{Key: key, Project: plugin.GCPProjectID, Category: categoryInvalidValue}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		keyErr.err = errors.Wrap(err, "value is not base64 encoded")
+		return keyErr
+	}
+	if err := rules.check(decoded); err != nil {
+		keyErr.err = err
+		return keyErr
+	}
+	if rules.NotEqualToEnvironment == "" {
+		return nil
+	}
+	other, err := inEnvironment(rules.NotEqualToEnvironment)
+	if err != nil {
+		keyErr.Category = errorCategory(err)
+		var otherErr *keyError
+		if errors.As(err, &otherErr) {
+			if otherErr.Category == categoryNotFound {
+				// nothing to compare with
+				return nil
+			}
+			keyErr.Category, err = otherErr.Category, otherErr.err
+		}
+		keyErr.err = errors.Wrapf(err, "could not compare with the value in environment '%s'", rules.NotEqualToEnvironment)
+		return keyErr
+	}
+	if other == value {
+		keyErr.err = errors.Errorf("value is equal to the value in environment '%s'", rules.NotEqualToEnvironment)
+		return keyErr
+	}
+	return nil
+}
+
+func (r ValueRules) check(value []byte) error {
+	if r.Regex != "" && !regexp.MustCompile(r.Regex).Match(value) {
+		return errors.Errorf("value does not match regex '%s'", r.Regex)
+	}
+	if r.NotRegex != "" && regexp.MustCompile(r.NotRegex).Match(value) {
+		return errors.Errorf("value matches notRegex '%s'", r.NotRegex)
+	}
+	length := len(value)
+	if utf8.Valid(value) {
+		length = utf8.RuneCount(value)
+	}
+	if length < r.MinLength {
+		return errors.Errorf("value is shorter than minLength %d", r.MinLength)
+	}
+	if r.MaxLength > 0 && length > r.MaxLength {
+		return errors.Errorf("value is longer than maxLength %d", r.MaxLength)
+	}
+	if r.Format != "" && !validFormat(r.Format, value) {
+		return errors.Errorf("value is not valid %s", r.Format)
+	}
+	return nil
+}
+
+func validFormat(format string, value []byte) bool {
+	switch format {
+	case formatJSON:
+		return json.Valid(value)
+	case formatYAML:
+		// any plain text is a valid YAML scalar, so only a mapping or a sequence counts
+		var parsed interface{}
+		if yaml.Unmarshal(value, &parsed) != nil {
+			return false
+		}
+		switch parsed.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			return true
+		}
+		return false
+	case formatPEM:
+		// pem.Decode skips text before the first block
+		if !bytes.HasPrefix(bytes.TrimSpace(value), []byte("-----BEGIN")) {
+			return false
+		}
+		block, rest := pem.Decode(value)
+		for block != nil && len(bytes.TrimSpace(rest)) > 0 {
+			block, rest = pem.Decode(rest)
+		}
+		return block != nil
+	case formatURL:
+		parsed, err := url.ParseRequestURI(string(value))
+		return err == nil && parsed.Scheme != "" && parsed.Host != ""
+	case formatUUID:
+		return uuidPattern.Match(value)
+	}
+	return false
+}
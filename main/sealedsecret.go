@@ -0,0 +1,213 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+
+	"github.com/pkg/errors"
+)
+
+// scopes of a SealedSecret, see https://github.com/bitnami-labs/sealed-secrets#scopes
+const (
+	sealingScopeStrict        = "strict"
+	sealingScopeNamespaceWide = "namespace-wide"
+	sealingScopeClusterWide   = "cluster-wide"
+)
+
+// SealedSecretOptions configures the SealedSecret generated with output sealedsecret
+type SealedSecretOptions struct {
+	Certificate     string `json:"certificate,omitempty" yaml:"certificate,omitempty"`
+	CertificateFile string `json:"certificateFile,omitempty" yaml:"certificateFile,omitempty"`
+	Scope           string `json:"scope,omitempty" yaml:"scope,omitempty"`
+}
+
+// K8SSealedSecret is a SealedSecret of the Bitnami sealed-secrets controller
+type K8SSealedSecret struct {
+	TypeMeta   `json:",inline" yaml:",inline"`
+	ObjectMeta `json:"metadata" yaml:"metadata"`
+	Spec       SealedSecretSpec `json:"spec" yaml:"spec"`
+}
+
+// SealedSecretSpec is the spec of a SealedSecret
+type SealedSecretSpec struct {
+	EncryptedData kvMap                `json:"encryptedData" yaml:"encryptedData"`
+	Template      SealedSecretTemplate `json:"template" yaml:"template"`
+}
+
+// SealedSecretTemplate describes the Kubernetes Secret created by the sealed-secrets controller
+type SealedSecretTemplate struct {
+	ObjectMeta `json:"metadata" yaml:"metadata"`
+	Type       string `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+func (p *KGCPSecret) sealingScope() string {
+	if p.SealedSecret.Scope != "" {
+		return p.SealedSecret.Scope
+	}
+	return sealingScopeStrict
+}
+
+func (p *KGCPSecret) validateSealedSecret() error {
+	options := p.SealedSecret
+	if (options.Certificate == "") == (options.CertificateFile == "") {
+		return errors.Errorf("output '%s' needs either sealedSecret.certificate or sealedSecret.certificateFile", outputSealedSecret)
+	}
+	switch p.sealingScope() {
+	case sealingScopeStrict, sealingScopeNamespaceWide:
+		if p.Namespace == "" {
+			return errors.Errorf("sealedSecret scope '%s' needs the namespace of the secret", p.sealingScope())
+		}
+	case sealingScopeClusterWide:
+	default:
+		return errors.Errorf("sealedSecret.scope must be one of '%s', '%s' or '%s', got '%s'",
+			sealingScopeStrict, sealingScopeNamespaceWide, sealingScopeClusterWide, options.Scope)
+	}
+	return nil
+}
+
+// sealingKey reads the public key from the sealed-secrets certificate
+func (p *KGCPSecret) sealingKey() (*rsa.PublicKey, error) {
+	certificate := []byte(p.SealedSecret.Certificate)
+	if p.SealedSecret.CertificateFile != "" {
+		var err error
+		if certificate, err = ioutil.ReadFile(p.SealedSecret.CertificateFile); err != nil {
+			return nil, errors.Wrap(err, "failed to read the sealed-secrets certificate")
+		}
+	}
+	block, _ := pem.Decode(certificate)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("the sealed-secrets certificate is not a PEM encoded certificate")
+	}
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the sealed-secrets certificate")
+	}
+	key, ok := parsed.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the sealed-secrets certificate does not contain an RSA public key")
+	}
+	return key, nil
+}
+
+// sealingLabel returns the label binding the encrypted values to the scope of the SealedSecret
+func (p *KGCPSecret) sealingLabel() []byte {
+	switch p.sealingScope() {
+	case sealingScopeNamespaceWide:
+		return []byte(p.Namespace)
+	case sealingScopeClusterWide:
+		return []byte{}
+	}
+	return []byte(p.Namespace + "/" + p.Name)
+}
+
+// hybridEncrypt encrypts the plaintext like kubeseal: a random AES-256-GCM session key, encrypted with
+// RSA-OAEP and prefixed by its length, followed by the plaintext sealed with the session key
+func hybridEncrypt(random io.Reader, publicKey *rsa.PublicKey, plaintext []byte, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := io.ReadFull(random, sessionKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), random, publicKey, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := make([]byte, 2, 2+len(encryptedKey)+len(plaintext)+aead.Overhead())
+	binary.BigEndian.PutUint16(ciphertext, uint16(len(encryptedKey)))
+	ciphertext = append(ciphertext, encryptedKey...)
+	// the session key is used only once, so a zero nonce is safe
+	return aead.Seal(ciphertext, make([]byte, aead.NonceSize()), plaintext, nil), nil
+}
+
+// GetSealedSecret resolves the keys like GetSecrets and encrypts the values with the sealed-secrets certificate
+func GetSealedSecret(ctx context.Context, client *secretmanager.Client,
+	plugin *KGCPSecret, listGCPSecrets secretsGetter, getGCPSecretValue secretValueGetter) (K8SSealedSecret, error) {
+	publicKey, err := plugin.sealingKey()
+	if err != nil {
+		return K8SSealedSecret{}, err
+	}
+	values, secretType, err := getSecretData(ctx, client, plugin, listGCPSecrets, getGCPSecretValue)
+	if err != nil {
+		return K8SSealedSecret{}, err
+	}
+
+	// Kustomize only adds a name suffix hash to ConfigMaps and Secrets, not to SealedSecrets
+	objectMeta := generatedObjectMeta(plugin)
+	delete(objectMeta.Annotations, "kustomize.config.k8s.io/needs-hash")
+
+	unsealed := K8SSecret{ObjectMeta: objectMeta, Data: values, Type: secretType}
+	if err := validateK8SSecret(unsealed); err != nil {
+		return K8SSealedSecret{}, err
+	}
+
+	label := plugin.sealingLabel()
+	encryptedData := make(kvMap)
+	for key, value := range values {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return K8SSealedSecret{}, errors.Wrapf(err, "value of key '%s' is not base64 encoded", key)
+		}
+		encrypted, err := hybridEncrypt(rand.Reader, publicKey, decoded, label)
+		if err != nil {
+			return K8SSealedSecret{}, errors.Wrapf(err, "failed to seal key '%s'", key)
+		}
+		encryptedData[key] = base64.StdEncoding.EncodeToString(encrypted)
+	}
+
+	switch plugin.sealingScope() {
+	case sealingScopeNamespaceWide:
+		objectMeta.Annotations["sealedsecrets.bitnami.com/namespace-wide"] = "true"
+	case sealingScopeClusterWide:
+		objectMeta.Annotations["sealedsecrets.bitnami.com/cluster-wide"] = "true"
+	}
+
+	return K8SSealedSecret{
+		TypeMeta: TypeMeta{
+			APIVersion: "bitnami.com/v1alpha1",
+			Kind:       "SealedSecret",
+		},
+		ObjectMeta: objectMeta,
+		Spec: SealedSecretSpec{
+			EncryptedData: encryptedData,
+			Template: SealedSecretTemplate{
+				ObjectMeta: pluginObjectMeta(plugin),
+				Type:       secretType,
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,137 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SecretProviderClassOptions configures the SecretProviderClass generated with output secretproviderclass
+type SecretProviderClassOptions struct {
+	// Paths maps keys to the paths of their files, which default to the keys
+	Paths map[string]string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	// SyncSecret adds secretObjects to sync the mounted files into a Kubernetes Secret
+	SyncSecret bool `json:"syncSecret,omitempty" yaml:"syncSecret,omitempty"`
+}
+
+// K8SSecretProviderClass is a SecretProviderClass of the Secrets Store CSI driver
+type K8SSecretProviderClass struct {
+	TypeMeta   `json:",inline" yaml:",inline"`
+	ObjectMeta `json:"metadata" yaml:"metadata"`
+	Spec       SecretProviderClassSpec `json:"spec" yaml:"spec"`
+}
+
+// SecretProviderClassSpec is the spec of a SecretProviderClass
+type SecretProviderClassSpec struct {
+	Provider      string                      `json:"provider" yaml:"provider"`
+	Parameters    map[string]string           `json:"parameters" yaml:"parameters"`
+	SecretObjects []SecretProviderClassObject `json:"secretObjects,omitempty" yaml:"secretObjects,omitempty"`
+}
+
+// SecretProviderClassObject describes a Kubernetes Secret synced from the mounted files
+type SecretProviderClassObject struct {
+	SecretName  string                          `json:"secretName" yaml:"secretName"`
+	Type        string                          `json:"type" yaml:"type"`
+	Labels      kvMap                           `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations kvMap                           `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Data        []SecretProviderClassObjectData `json:"data" yaml:"data"`
+}
+
+// SecretProviderClassObjectData maps a mounted file to a key of the synced Kubernetes Secret
+type SecretProviderClassObjectData struct {
+	ObjectName string `json:"objectName" yaml:"objectName"`
+	Key        string `json:"key" yaml:"key"`
+}
+
+// gcpProviderSecret is an entry of the secrets parameter of the GCP provider
+type gcpProviderSecret struct {
+	ResourceName string `yaml:"resourceName"`
+	Path         string `yaml:"path"`
+}
+
+// GetSecretProviderClass resolves the keys like GetSecrets, but creates a SecretProviderClass for the GCP provider
+// of the Secrets Store CSI driver which mounts the resolved secret versions as files
+func GetSecretProviderClass(ctx context.Context, client *secretmanager.Client,
+	plugin *KGCPSecret, listGCPSecrets secretsGetter, getGCPSecretValue secretValueGetter) (K8SSecretProviderClass, error) {
+	if plugin.explanation == nil {
+		// the resolved secrets and versions are taken from the explanation
+		plugin.explanation = newExplanation()
+	}
+	values, err := createGCPSecretValuesGetter(plugin, listGCPSecrets)(ctx, client, plugin, getGCPSecretValue)
+	if err != nil {
+		return K8SSecretProviderClass{}, err
+	}
+	references, err := secretReferences(plugin, values, "a SecretProviderClass")
+	if err != nil {
+		return K8SSecretProviderClass{}, err
+	}
+
+	options := plugin.SecretProviderClass
+	secrets := []gcpProviderSecret{}
+	objectData := []SecretProviderClassObjectData{}
+	for _, reference := range references {
+		version := plugin.explanation.resolvedVersion(reference.secret)
+		if version == "" {
+			version = "latest"
+		}
+		path := reference.key
+		if options.Paths[reference.key] != "" {
+			path = options.Paths[reference.key]
+		}
+		secrets = append(secrets, gcpProviderSecret{
+			ResourceName: fmt.Sprintf("projects/%s/secrets/%s/versions/%s",
+				plugin.GCPProjectID, sanitizeKeyName(reference.secret), version),
+			Path: path,
+		})
+		objectData = append(objectData, SecretProviderClassObjectData{ObjectName: path, Key: reference.key})
+	}
+	secretsParameter, err := yaml.Marshal(secrets)
+	if err != nil {
+		return K8SSecretProviderClass{}, err
+	}
+
+	secretProviderClass := K8SSecretProviderClass{
+		TypeMeta: TypeMeta{
+			APIVersion: "secrets-store.csi.x-k8s.io/v1",
+			Kind:       "SecretProviderClass",
+		},
+		ObjectMeta: pluginObjectMeta(plugin),
+		Spec: SecretProviderClassSpec{
+			Provider:   "gcp",
+			Parameters: map[string]string{"secrets": string(secretsParameter)},
+		},
+	}
+	if options.SyncSecret {
+		secretType := plugin.Type
+		if secretType == "" {
+			secretType = typeOpaque
+		}
+		secretProviderClass.Spec.SecretObjects = []SecretProviderClassObject{{
+			SecretName:  plugin.Name,
+			Type:        secretType,
+			Labels:      kvMap(plugin.Labels),
+			Annotations: kvMap(plugin.Annotations),
+			Data:        objectData,
+		}}
+	}
+	return secretProviderClass, nil
+}
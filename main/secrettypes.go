@@ -0,0 +1,99 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+)
+
+// built-in Kubernetes secret types without builders, and the type inferred from the data
+const (
+	typeOpaque              = "Opaque"
+	typeServiceAccountToken = "kubernetes.io/service-account-token"
+	typeDockerCfg           = "kubernetes.io/dockercfg"
+	typeSSHAuth             = "kubernetes.io/ssh-auth"
+	typeBootstrapToken      = "bootstrap.kubernetes.io/token"
+	typeAuto                = "auto"
+)
+
+const sshPrivateKeyKey = "ssh-privatekey"
+
+var builtinSecretTypes = []string{
+	typeOpaque, typeServiceAccountToken, typeDockerCfg, typeDockerConfigJSON,
+	typeBasicAuth, typeSSHAuth, typeTLS, typeBootstrapToken,
+}
+
+// allowsType tells if the secret can be of the given type, either set or inferred from the data
+func (p *KGCPSecret) allowsType(secretType string) bool {
+	return p.Type == secretType || p.Type == typeAuto
+}
+
+// secretType returns the type of the secret with the data, inferring it for type auto
+func (p *KGCPSecret) secretType(data kvMap) string {
+	inferred := inferSecretType(data)
+	if p.Type == typeAuto {
+		return inferred
+	}
+	for _, builtin := range builtinSecretTypes {
+		if p.Type != builtin && strings.EqualFold(p.Type, builtin) {
+			p.warn("type '" + p.Type + "' is not the built-in type '" + builtin + "', Kubernetes treats it as a custom type")
+		}
+	}
+	if containsKey(builtinSecretTypes, p.Type) && inferred != p.Type && inferred != typeOpaque {
+		p.warn("type '" + p.Type + "' does not match the data of secret '" + p.Name + "', which looks like type '" + inferred + "'")
+	}
+	return p.Type
+}
+
+// inferSecretType returns the built-in type the keys and values of the data fit, or Opaque
+func inferSecretType(data kvMap) string {
+	decoded := func(key string) []byte {
+		value, _ := base64.StdEncoding.DecodeString(data[key])
+		return value
+	}
+	pemType := func(key string) string {
+		block, _ := pem.Decode(decoded(key))
+		if block == nil {
+			return ""
+		}
+		return block.Type
+	}
+	_, hasCertificate := data[tlsCertificateKey]
+	_, hasPrivateKey := data[tlsPrivateKeyKey]
+	_, hasDockerConfigJSON := data[dockerConfigJSONKey]
+	_, hasDockerCfg := data[".dockercfg"]
+	_, hasSSHPrivateKey := data[sshPrivateKeyKey]
+	switch {
+	case hasCertificate && hasPrivateKey && pemType(tlsCertificateKey) == "CERTIFICATE":
+		return typeTLS
+	case hasDockerConfigJSON && json.Valid(decoded(dockerConfigJSONKey)):
+		return typeDockerConfigJSON
+	case hasDockerCfg && json.Valid(decoded(".dockercfg")):
+		return typeDockerCfg
+	case hasSSHPrivateKey && strings.HasSuffix(pemType(sshPrivateKeyKey), "PRIVATE KEY"):
+		return typeSSHAuth
+	}
+	_, hasUsername := data[basicAuthUsernameKey]
+	_, hasPassword := data[basicAuthPasswordKey]
+	if hasUsername && hasPassword && len(data) == 2 {
+		return typeBasicAuth
+	}
+	return typeOpaque
+}
@@ -0,0 +1,216 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeySelector adds all secrets to a KGCPSecret whose base name (the name without the prefixes and
+// postfixes of the lookup) matches the glob and the regex, and whose labels match the label selector.
+// Conditions that are not set match every secret.
+type KeySelector struct {
+	Glob   string `json:"glob,omitempty" yaml:"glob,omitempty"`
+	Regex  string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Labels string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// labeledSecretsGetter lists the secrets in a project matching a Secret Manager filter
+type labeledSecretsGetter func(projectID string, filter string) ([]string, error)
+
+var labelKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+func (s KeySelector) validate() error {
+	if s.Glob == "" && s.Regex == "" && s.Labels == "" {
+		return errors.New("at least one of glob, regex and labels must be set")
+	}
+	if _, err := path.Match(s.Glob, ""); err != nil {
+		return errors.Wrapf(err, "invalid glob '%s'", s.Glob)
+	}
+	if _, err := regexp.Compile(s.Regex); err != nil {
+		return errors.Wrapf(err, "invalid regex '%s'", s.Regex)
+	}
+	if _, err := labelFilter(s.Labels); err != nil {
+		return err
+	}
+	return nil
+}
+
+// labelFilter translates a Kubernetes style label selector like "app=checkout,tier!=db,team" into
+// a Secret Manager filter
+func labelFilter(selector string) (string, error) {
+	if selector == "" {
+		return "", nil
+	}
+	terms := []string{}
+	for _, requirement := range strings.Split(selector, ",") {
+		requirement = strings.TrimSpace(requirement)
+		var key, value, term string
+		switch {
+		case strings.Contains(requirement, "!="):
+			parts := strings.SplitN(requirement, "!=", 2)
+			key, value = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			term = "NOT labels." + key + "=" + value
+		case strings.Contains(requirement, "="):
+			parts := strings.SplitN(strings.Replace(requirement, "==", "=", 1), "=", 2)
+			key, value = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			term = "labels." + key + "=" + value
+		default:
+			key = requirement
+			term = "labels." + key + ":*"
+		}
+		if !labelKeyPattern.MatchString(key) || strings.ContainsAny(value, " =!,") {
+			return "", errors.Errorf("invalid label selector '%s'", selector)
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " AND "), nil
+}
+
+// baseName strips the most specific prefix and postfix of the lookup from a secret name. Prefixes and
+// postfixes with empty parts, e.g. for a KGCPSecret without namespace, are not stripped.
+// It reports whether the postfix of the environment and tag of the KGCPSecret was stripped.
+func baseName(plugin *KGCPSecret, secret string) (string, bool) {
+	namespace, name, environment, tag := plugin.Namespace, plugin.Name, plugin.environment(), plugin.tag()
+	prefixes := []string{}
+	if namespace != "" {
+		prefixes = append(prefixes, namespace+"_"+name+"_")
+	}
+	prefixes = append(prefixes, name+"_")
+	if namespace != "" {
+		prefixes = append(prefixes, namespace+"_")
+	}
+	postfixes := []string{}
+	if environment != "" && tag != "" {
+		postfixes = append(postfixes, "_"+environment+"_"+tag)
+	}
+	if environment != "" {
+		postfixes = append(postfixes, "_"+environment)
+	}
+	if tag != "" {
+		postfixes = append(postfixes, "_"+tag)
+	}
+
+	base := secret
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(base, prefix) && len(base) > len(prefix) {
+			base = strings.TrimPrefix(base, prefix)
+			break
+		}
+	}
+	for _, postfix := range postfixes {
+		if strings.HasSuffix(base, postfix) && len(base) > len(postfix) {
+			return strings.TrimSuffix(base, postfix), true
+		}
+	}
+	return base, false
+}
+
+// otherPostfixes collects the postfixes of the secrets of other environments and tags. A base name with a secret
+// for the environment or tag of the KGCPSecret, e.g. APP_URL_prod, marks the postfixes of its other secrets,
+// e.g. APP_URL_pp or APP_URL_pp_be-gcw1, as ones of other environments or tags.
+func otherPostfixes(plugin *KGCPSecret, secrets []string) map[string]bool {
+	bases := map[string]bool{}
+	for _, secret := range secrets {
+		if base, ok := baseName(plugin, secret); ok {
+			bases[base] = true
+		}
+	}
+	others := map[string]bool{}
+	for _, secret := range secrets {
+		name, ok := baseName(plugin, secret)
+		if ok {
+			continue
+		}
+		for base := range bases {
+			if strings.HasPrefix(name, base+"_") {
+				for _, postfix := range strings.Split(strings.TrimPrefix(name, base+"_"), "_") {
+					others[postfix] = true
+				}
+			}
+		}
+	}
+	return others
+}
+
+// otherEnvironment tells if a name without the postfixes of the KGCPSecret ends with the postfix of another
+// environment or tag, or with the environment of the KGCPSecret and another tag
+func otherEnvironment(plugin *KGCPSecret, name string, others map[string]bool) bool {
+	parts := strings.Split(name, "_")
+	last := len(parts) - 1
+	if last >= 1 && others[parts[last]] {
+		return true
+	}
+	environment := plugin.environment()
+	return last >= 2 && (others[parts[last-1]] || environment != "" && parts[last-1] == environment)
+}
+
+// selectKeys returns the base names of the secrets matching the keySelectors of the KGCPSecret,
+// sorted and without the keys listed explicitly
+func selectKeys(plugin *KGCPSecret, allKeys []string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, selector := range plugin.KeySelectors {
+		candidates := allKeys
+		if selector.Labels != "" {
+			filter, err := labelFilter(selector.Labels)
+			if err != nil {
+				return nil, err
+			}
+			if plugin.listLabeledSecrets == nil {
+				return nil, errors.New("label selectors need access to Google Secret Manager")
+			}
+			candidates, err = plugin.listLabeledSecrets(plugin.GCPProjectID, filter)
+			if err != nil {
+				return nil, err
+			}
+		}
+		regex, err := regexp.Compile(selector.Regex)
+		if err != nil {
+			return nil, err
+		}
+		others := otherPostfixes(plugin, candidates)
+		for _, secret := range candidates {
+			base, _ := baseName(plugin, secret)
+			// also for stripped names, e.g. APP_URL_pp_be-gcw1 has the tag of the KGCPSecret, but another environment
+			if otherEnvironment(plugin, base, others) {
+				continue
+			}
+			if matched, _ := path.Match(selector.Glob, base); selector.Glob != "" && !matched {
+				continue
+			}
+			if !regex.MatchString(base) {
+				continue
+			}
+			selected[base] = true
+		}
+	}
+	for _, key := range plugin.Keys {
+		delete(selected, key)
+	}
+
+	keys := make([]string, 0, len(selected))
+	for key := range selected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,290 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// SOPS metadata written to the encrypted documents
+const (
+	sopsVersion        = "3.9.0"
+	sopsEncryptedRegex = "^(data|stringData)$"
+)
+
+var (
+	sopsEncryptedKey = regexp.MustCompile(sopsEncryptedRegex)
+	pgpFingerprint   = regexp.MustCompile(`^[0-9A-F]{40}$`)
+)
+
+// SOPSOptions lists the recipients of the SOPS document generated with output sops
+type SOPSOptions struct {
+	Age []string `json:"age,omitempty" yaml:"age,omitempty"`
+	PGP []string `json:"pgp,omitempty" yaml:"pgp,omitempty"`
+}
+
+func (p *KGCPSecret) validateSOPS() error {
+	if len(p.SOPS.Age) == 0 && len(p.SOPS.PGP) == 0 {
+		return errors.Errorf("output '%s' needs at least one age or pgp recipient in sops", outputSOPS)
+	}
+	if p.Behavior != "" {
+		// Kustomize would merge the encrypted values with values of another document, which has another data key
+		return errors.Errorf("behavior cannot be used with output '%s'", outputSOPS)
+	}
+	for _, recipient := range p.SOPS.Age {
+		if _, err := age.ParseX25519Recipient(recipient); err != nil {
+			return errors.Wrapf(err, "invalid age recipient '%s'", recipient)
+		}
+	}
+	for _, fingerprint := range p.SOPS.PGP {
+		if !pgpFingerprint.MatchString(normalizePGPFingerprint(fingerprint)) {
+			return errors.Errorf("pgp recipients must be 40 hex digit fingerprints, got '%s'", fingerprint)
+		}
+	}
+	return nil
+}
+
+func normalizePGPFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+}
+
+// GetSOPSSecret creates the Kubernetes secret like GetSecrets and encrypts its data and stringData with SOPS
+// for the configured recipients
+func GetSOPSSecret(ctx context.Context, client *secretmanager.Client,
+	plugin *KGCPSecret, listGCPSecrets secretsGetter, getGCPSecretValue secretValueGetter) (yaml.MapSlice, error) {
+	// the values are encrypted with a new data key on every build, a name suffix hash would change every time
+	unhashed := *plugin
+	unhashed.DisableNameSuffixHash = true
+	secret, err := GetSecrets(ctx, client, &unhashed, listGCPSecrets, getGCPSecretValue)
+	if err != nil {
+		return nil, err
+	}
+	// SOPS authenticates the values in the order of the document, so encrypt the document as it is written
+	content, err := yaml.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	mac := sha512.New()
+	if err := encryptSOPSBranch(document, nil, dataKey, mac); err != nil {
+		return nil, err
+	}
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	encryptedMAC, err := encryptSOPSValue(fmt.Sprintf("%X", mac.Sum(nil)), dataKey, lastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := yaml.MapSlice{}
+	if len(plugin.SOPS.Age) > 0 {
+		recipients := []yaml.MapSlice{}
+		for _, recipient := range plugin.SOPS.Age {
+			enc, err := encryptForAge(recipient, dataKey)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to encrypt the data key for age recipient '%s'", recipient)
+			}
+			recipients = append(recipients, yaml.MapSlice{{Key: "recipient", Value: recipient}, {Key: "enc", Value: enc}})
+		}
+		metadata = append(metadata, yaml.MapItem{Key: "age", Value: recipients})
+	}
+	if len(plugin.SOPS.PGP) > 0 {
+		recipients := []yaml.MapSlice{}
+		for _, fingerprint := range plugin.SOPS.PGP {
+			fingerprint = normalizePGPFingerprint(fingerprint)
+			enc, err := encryptForPGP(fingerprint, dataKey)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to encrypt the data key for pgp recipient '%s'", fingerprint)
+			}
+			recipients = append(recipients, yaml.MapSlice{{Key: "created_at", Value: lastModified}, {Key: "enc", Value: enc}, {Key: "fp", Value: fingerprint}})
+		}
+		metadata = append(metadata, yaml.MapItem{Key: "pgp", Value: recipients})
+	}
+	metadata = append(metadata,
+		yaml.MapItem{Key: "lastmodified", Value: lastModified},
+		yaml.MapItem{Key: "mac", Value: encryptedMAC},
+		yaml.MapItem{Key: "encrypted_regex", Value: sopsEncryptedRegex},
+		yaml.MapItem{Key: "mac_only_encrypted", Value: true},
+		yaml.MapItem{Key: "version", Value: sopsVersion},
+	)
+	return append(document, yaml.MapItem{Key: "sops", Value: metadata}), nil
+}
+
+// encryptSOPSBranch walks the document like SOPS: the values below data and stringData are encrypted in place
+// and added to the message authentication code. As only they are authenticated, Kustomize transformers may
+// change the metadata of the document.
+func encryptSOPSBranch(branch yaml.MapSlice, path []string, dataKey []byte, mac hash.Hash) error {
+	for i, item := range branch {
+		itemPath := append(append([]string{}, path...), fmt.Sprint(item.Key))
+		encrypted, err := encryptSOPSNode(item.Value, itemPath, dataKey, mac)
+		if err != nil {
+			return err
+		}
+		branch[i].Value = encrypted
+	}
+	return nil
+}
+
+func encryptSOPSNode(node interface{}, path []string, dataKey []byte, mac hash.Hash) (interface{}, error) {
+	switch value := node.(type) {
+	case yaml.MapSlice:
+		return value, encryptSOPSBranch(value, path, dataKey, mac)
+	case []interface{}:
+		for i, element := range value {
+			encrypted, err := encryptSOPSNode(element, path, dataKey, mac)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = encrypted
+		}
+		return value, nil
+	case nil:
+		// SOPS neither authenticates nor encrypts null values
+		return nil, nil
+	case string, int, int64, uint64, float64, bool:
+		if !sopsEncryptsPath(path) {
+			return value, nil
+		}
+		mac.Write([]byte(sopsMACValue(value)))
+		return encryptSOPSValue(value, dataKey, strings.Join(path, ":")+":")
+	}
+	return nil, errors.Errorf("unexpected value of type %T at '%s'", node, strings.Join(path, "."))
+}
+
+// sopsMACValue returns the string form SOPS adds to the message authentication code for a value
+func sopsMACValue(value interface{}) string {
+	switch value := value.(type) {
+	case bool:
+		if value {
+			return "True"
+		}
+		return "False"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// sopsPlaintext returns the plaintext and the type SOPS encrypts a value with
+func sopsPlaintext(value interface{}) (string, string) {
+	switch value := value.(type) {
+	case string:
+		return value, "str"
+	case bool:
+		return strconv.FormatBool(value), "bool"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), "float"
+	}
+	return fmt.Sprint(value), "int"
+}
+
+func sopsEncryptsPath(path []string) bool {
+	for _, key := range path {
+		if sopsEncryptedKey.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptSOPSValue encrypts a value with AES-GCM in the format of SOPS
+func encryptSOPSValue(value interface{}, dataKey []byte, additionalData string) (string, error) {
+	plaintext, valueType := sopsPlaintext(value)
+	iv := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nil, iv, []byte(plaintext), []byte(additionalData))
+	tagStart := len(sealed) - aead.Overhead()
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(sealed[:tagStart]),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(sealed[tagStart:]), valueType), nil
+}
+
+func encryptForAge(recipient string, dataKey []byte) (string, error) {
+	parsed, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, parsed)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(dataKey); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := armored.Close(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// encryptForPGP encrypts the data key with the gpg binary like SOPS, so the public key needs to be in the keyring
+func encryptForPGP(fingerprint string, dataKey []byte) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--no-default-recipient", "--yes", "--encrypt", "-a", "-r", fingerprint,
+		"--trusted-key", fingerprint[len(fingerprint)-16:], "--no-encrypt-to")
+	cmd.Stdin = bytes.NewReader(dataKey)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Errorf("gpg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
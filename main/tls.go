@@ -0,0 +1,163 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// keys of a kubernetes.io/tls secret
+const (
+	tlsCertificateKey = "tls.crt"
+	tlsPrivateKeyKey  = "tls.key"
+)
+
+// what to do when the certificate expires within the expiry window
+const (
+	onExpiryWarn = "warn"
+	onExpiryFail = "fail"
+)
+
+// TLSOptions assembles and checks a kubernetes.io/tls secret
+type TLSOptions struct {
+	CertificateKey string `json:"certificateKey,omitempty" yaml:"certificateKey,omitempty"`
+	ChainKey       string `json:"chainKey,omitempty" yaml:"chainKey,omitempty"`
+	PrivateKeyKey  string `json:"privateKeyKey,omitempty" yaml:"privateKeyKey,omitempty"`
+	ExpiryWindow   string `json:"expiryWindow,omitempty" yaml:"expiryWindow,omitempty"`
+	OnExpiry       string `json:"onExpiry,omitempty" yaml:"onExpiry,omitempty"`
+}
+
+// assembles tells if the tls.crt and tls.key are assembled from other keys
+func (o TLSOptions) assembles() bool {
+	return o.CertificateKey != "" || o.ChainKey != "" || o.PrivateKeyKey != ""
+}
+
+func (o TLSOptions) keys() []string {
+	var keys []string
+	for _, key := range []string{o.CertificateKey, o.ChainKey, o.PrivateKeyKey} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (o TLSOptions) expiryWindow() (time.Duration, error) {
+	if o.ExpiryWindow == "" {
+		return 0, nil
+	}
+	window, err := time.ParseDuration(o.ExpiryWindow)
+	if err != nil || window <= 0 {
+		return 0, errors.Errorf("tls.expiryWindow must be a positive duration like '720h', got '%s'", o.ExpiryWindow)
+	}
+	return window, nil
+}
+
+func (o TLSOptions) validate() error {
+	if o.assembles() && (o.CertificateKey == "" || o.PrivateKeyKey == "") {
+		return errors.New("tls.certificateKey and tls.privateKeyKey must be set to assemble the secret")
+	}
+	if _, err := o.expiryWindow(); err != nil {
+		return err
+	}
+	switch o.OnExpiry {
+	case "", onExpiryWarn, onExpiryFail:
+	default:
+		return errors.Errorf("tls.onExpiry must be '%s' or '%s', got '%s'", onExpiryWarn, onExpiryFail, o.OnExpiry)
+	}
+	return nil
+}
+
+// buildTLS assembles tls.crt from the certificate and the chain of intermediates and tls.key from the private key
+func buildTLS(options TLSOptions, data kvMap) (certificate []byte, privateKey []byte, err error) {
+	if certificate, err = decodedValue(data, options.CertificateKey); err != nil {
+		return nil, nil, errors.Wrap(err, "no certificate for tls.crt")
+	}
+	if options.ChainKey != "" {
+		chain, err := decodedValue(data, options.ChainKey)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "no chain for tls.crt")
+		}
+		if len(certificate) > 0 && certificate[len(certificate)-1] != '\n' {
+			certificate = append(certificate, '\n')
+		}
+		certificate = append(certificate, chain...)
+	}
+	if privateKey, err = decodedValue(data, options.PrivateKeyKey); err != nil {
+		return nil, nil, errors.Wrap(err, "no private key for tls.key")
+	}
+	return certificate, privateKey, nil
+}
+
+// validateTLS checks that tls.crt contains PEM encoded certificates, that tls.key is the private key of the
+// first one, that it has not expired and how long it is still valid
+func validateTLS(plugin *KGCPSecret, data kvMap) error {
+	certificate, err := decodedValue(data, tlsCertificateKey)
+	if err != nil {
+		return errors.Wrapf(err, "a secret of type %s needs the key %s", typeTLS, tlsCertificateKey)
+	}
+	privateKey, err := decodedValue(data, tlsPrivateKeyKey)
+	if err != nil {
+		return errors.Wrapf(err, "a secret of type %s needs the key %s", typeTLS, tlsPrivateKeyKey)
+	}
+	rest := certificate
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return errors.Errorf("%s contains a PEM block of type '%s' instead of a certificate", tlsCertificateKey, block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return errors.Wrapf(err, "%s contains an invalid certificate", tlsCertificateKey)
+		}
+	}
+	pair, err := tls.X509KeyPair(certificate, privateKey)
+	if err != nil {
+		return errors.Wrapf(err, "%s and %s are not a valid key pair", tlsCertificateKey, tlsPrivateKeyKey)
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return errors.Wrapf(err, "%s contains an invalid certificate", tlsCertificateKey)
+	}
+	if !time.Now().Before(leaf.NotAfter) {
+		return errors.Errorf("the certificate in %s of secret '%s' expired at %s",
+			tlsCertificateKey, plugin.Name, leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	window, err := plugin.TLS.expiryWindow()
+	if err != nil || window == 0 {
+		return err
+	}
+	if time.Now().Add(window).Before(leaf.NotAfter) {
+		return nil
+	}
+	message := errors.Errorf("the certificate in %s of secret '%s' expires at %s, within the expiry window of %s",
+		tlsCertificateKey, plugin.Name, leaf.NotAfter.UTC().Format(time.RFC3339), plugin.TLS.ExpiryWindow)
+	if plugin.TLS.OnExpiry == onExpiryFail {
+		return message
+	}
+	plugin.warn(message.Error())
+	return nil
+}
@@ -0,0 +1,110 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// transforms maps the names of the transforms to the functions changing the value
+var transforms = map[string]func([]byte) ([]byte, error){
+	"base64Decode": func(value []byte) ([]byte, error) {
+		return base64.StdEncoding.DecodeString(string(bytes.TrimSpace(value)))
+	},
+	"base64Encode": func(value []byte) ([]byte, error) {
+		return []byte(base64.StdEncoding.EncodeToString(value)), nil
+	},
+	"trimSpace": func(value []byte) ([]byte, error) {
+		return bytes.TrimSpace(value), nil
+	},
+	"trimTrailingNewline": func(value []byte) ([]byte, error) {
+		return bytes.TrimRight(value, "\r\n"), nil
+	},
+	"gunzip": func(value []byte) ([]byte, error) {
+		r, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	},
+	"toUpper": func(value []byte) ([]byte, error) {
+		return bytes.ToUpper(value), nil
+	},
+	"toLower": func(value []byte) ([]byte, error) {
+		return bytes.ToLower(value), nil
+	},
+	"crlfToLf": func(value []byte) ([]byte, error) {
+		return bytes.ReplaceAll(value, []byte("\r\n"), []byte("\n")), nil
+	},
+	"lfToCrlf": func(value []byte) ([]byte, error) {
+		normalized := bytes.ReplaceAll(value, []byte("\r\n"), []byte("\n"))
+		return bytes.ReplaceAll(normalized, []byte("\n"), []byte("\r\n")), nil
+	},
+}
+
+// transformNames returns the sorted names of all transforms for error messages
+func transformNames() string {
+	names := make([]string, 0, len(transforms))
+	for name := range transforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// validateTransforms checks that all transforms of a key exist and that the output uses the values
+func (o KeyOptions) validateTransforms(output string) error {
+	if len(o.Transforms) > 0 && (output == outputExternalSecret || output == outputSecretProvider) {
+		// these outputs reference the secrets in Google Secret Manager, the values are never transformed
+		return errors.Errorf("transforms cannot be used with output '%s'", output)
+	}
+	for _, name := range o.Transforms {
+		if _, ok := transforms[name]; !ok {
+			return errors.Errorf("unknown transform '%s', must be one of %s", name, transformNames())
+		}
+	}
+	return nil
+}
+
+// transformValue applies the transforms of the key in order to the base64 encoded value
+func transformValue(plugin *KGCPSecret, key string, value string) (string, error) {
+	names := plugin.KeyOptions[key].Transforms
+	if len(names) == 0 {
+		return value, nil
+	}
+	keyErr := &keyError{Key: key, Project: plugin.GCPProjectID, Category: categoryInvalidValue}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		keyErr.err = errors.Wrap(err, "value is not base64 encoded")
+		return "", keyErr
+	}
+	for _, name := range names {
+		if decoded, err = transforms[name](decoded); err != nil {
+			keyErr.err = errors.Errorf("transform %s failed: %v", name, err)
+			return "", keyErr
+		}
+	}
+	return base64.StdEncoding.EncodeToString(decoded), nil
+}
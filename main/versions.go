@@ -0,0 +1,224 @@
+//
+// Copyright 2021 METRO Digital GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"google.golang.org/api/iterator"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/pkg/errors"
+)
+
+// asOfTime returns the point in time the secret is rendered for, or the zero time
+// if the latest versions should be used
+func (p *KGCPSecret) asOfTime() (time.Time, error) {
+	if p.AsOf == "" {
+		return time.Time{}, nil
+	}
+	asOf, err := time.Parse(time.RFC3339, p.AsOf)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "asOf must be an RFC 3339 timestamp, got '%s'", p.AsOf)
+	}
+	return asOf, nil
+}
+
+// minVersionAge returns how old a secret version has to be at least before it is used
+func (p *KGCPSecret) minVersionAge() (time.Duration, error) {
+	if p.MinVersionAge == "" {
+		return 0, nil
+	}
+	age, err := time.ParseDuration(p.MinVersionAge)
+	if err != nil || age < 0 {
+		return 0, errors.Errorf("minVersionAge must be a positive duration like '24h', got '%s'", p.MinVersionAge)
+	}
+	return age, nil
+}
+
+// versionCutoff returns the point in time the used secret versions must have been created before,
+// or the zero time if the latest versions should be used
+func (p *KGCPSecret) versionCutoff() (time.Time, error) {
+	asOf, err := p.asOfTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	age, err := p.minVersionAge()
+	if err != nil || age == 0 {
+		return asOf, err
+	}
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+	return asOf.Add(-age), nil
+}
+
+// versionStateError reports that a secret exists, but has no version that can be used.
+// It stops the lookup of less specific secrets.
+type versionStateError struct {
+	secret  string
+	message string
+}
+
+func (e *versionStateError) Error() string {
+	return fmt.Sprintf("secret %s %s", e.secret, e.message)
+}
+
+func (e *versionStateError) stopsLookup() {}
+
+func (e *versionStateError) category() string {
+	return categoryVersionState
+}
+
+// findGCPSecretVersion returns the id of the newest enabled version of a secret which was created
+// before the given point in time
+func findGCPSecretVersion(ctx context.Context, client *secretmanager.Client, caller *gcpCaller,
+	plugin *KGCPSecret, parent string, cutoff time.Time, pointInTime bool) (string, error) {
+	versions, err := listGCPSecretVersions(ctx, client, caller, parent)
+	if err != nil {
+		return "", err
+	}
+	return secretVersionAt(parent, versions, cutoff, pointInTime, plugin.FallbackToEnabledVersion)
+}
+
+// secretVersionAt returns the id of the newest enabled version of a secret created before the cutoff. A secret
+// without such a version stops the lookup, as less specific secrets must not be used because of the version state.
+// For a point in time render a version which was destroyed after that time was the one used then, so an older
+// version is only used with fallbackToEnabledVersion.
+func secretVersionAt(parent string, versions []*secretmanagerpb.SecretVersion, cutoff time.Time,
+	pointInTime bool, fallback bool) (string, error) {
+	version := selectSecretVersion(versions, cutoff)
+	if newest := newestSecretVersion(versions, cutoff); pointInTime && !fallback && version != nil &&
+		newest != version && newest.GetState() == secretmanagerpb.SecretVersion_DESTROYED &&
+		newest.GetDestroyTime().AsTime().After(cutoff) {
+		message := fmt.Sprintf("has version %s as the newest version created before %s, but it was destroyed at %s "+
+			"(set fallbackToEnabledVersion to use version %s)", path.Base(newest.GetName()), cutoff.Format(time.RFC3339),
+			newest.GetDestroyTime().AsTime().UTC().Format(time.RFC3339), path.Base(version.GetName()))
+		return "", &versionStateError{secret: parent, message: message}
+	}
+	if version == nil {
+		if selectSecretVersion(versions, time.Time{}) == nil {
+			return "", &versionStateError{secret: parent, message: "has no enabled versions"}
+		}
+		message := fmt.Sprintf("has no enabled version created before %s", cutoff.Format(time.RFC3339))
+		return "", &versionStateError{secret: parent, message: message}
+	}
+	return path.Base(version.GetName()), nil
+}
+
+// findEnabledGCPSecretVersion is used when the latest version of a secret is disabled or destroyed.
+// It returns the id of the newest enabled version if the KGCPSecret allows to use it.
+func findEnabledGCPSecretVersion(ctx context.Context, client *secretmanager.Client, caller *gcpCaller,
+	plugin *KGCPSecret, parent string) (string, error) {
+	versions, err := listGCPSecretVersions(ctx, client, caller, parent)
+	if err != nil {
+		return "", err
+	}
+
+	enabled := selectSecretVersion(versions, time.Time{})
+	if enabled == nil {
+		return "", &versionStateError{secret: parent, message: "has no enabled versions"}
+	}
+	if !plugin.FallbackToEnabledVersion {
+		message := fmt.Sprintf("has a disabled or destroyed latest version, the newest enabled version is %s "+
+			"(set fallbackToEnabledVersion to use it)", path.Base(enabled.GetName()))
+		return "", &versionStateError{secret: parent, message: message}
+	}
+	return path.Base(enabled.GetName()), nil
+}
+
+// getGCPSecretVersionMetadata reads the metadata of a secret version without its payload. Like AccessSecretVersion
+// it fails with FailedPrecondition for a version which is not enabled, so both are handled the same way.
+func getGCPSecretVersionMetadata(ctx context.Context, client *secretmanager.Client, caller *gcpCaller,
+	name string) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	var version *secretmanagerpb.SecretVersion
+	err := caller.call(ctx, "get secret version "+name, func(ctx context.Context) (err error) {
+		version, err = client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{Name: name}, noClientRetry)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if version.GetState() != secretmanagerpb.SecretVersion_ENABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is in %s state", version.GetName(), version.GetState())
+	}
+	return &secretmanagerpb.AccessSecretVersionResponse{Name: version.GetName()}, nil
+}
+
+func listGCPSecretVersions(ctx context.Context, client *secretmanager.Client, caller *gcpCaller,
+	parent string) ([]*secretmanagerpb.SecretVersion, error) {
+	versions := []*secretmanagerpb.SecretVersion{}
+
+	err := caller.call(ctx, "list versions of secret "+parent, func(ctx context.Context) error {
+		versions = versions[:0]
+		it := client.ListSecretVersions(ctx, &secretmanagerpb.ListSecretVersionsRequest{Parent: parent}, noClientRetry)
+		for {
+			resp, err := it.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			versions = append(versions, resp)
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "trouble listing versions of secret: %s", parent)
+	}
+	return versions, nil
+}
+
+// newestSecretVersion returns the newest version created before the cutoff, whatever its state
+func newestSecretVersion(versions []*secretmanagerpb.SecretVersion, cutoff time.Time) *secretmanagerpb.SecretVersion {
+	var newest *secretmanagerpb.SecretVersion
+	for _, version := range versions {
+		created := version.GetCreateTime().AsTime()
+		if created.After(cutoff) {
+			continue
+		}
+		if newest == nil || created.After(newest.GetCreateTime().AsTime()) {
+			newest = version
+		}
+	}
+	return newest
+}
+
+// selectSecretVersion picks the newest enabled version created before the cutoff. A zero cutoff
+// selects the newest enabled version.
+func selectSecretVersion(versions []*secretmanagerpb.SecretVersion, cutoff time.Time) *secretmanagerpb.SecretVersion {
+	var selected *secretmanagerpb.SecretVersion
+	for _, version := range versions {
+		if version.GetState() != secretmanagerpb.SecretVersion_ENABLED {
+			continue
+		}
+		created := version.GetCreateTime().AsTime()
+		if !cutoff.IsZero() && created.After(cutoff) {
+			continue
+		}
+		if selected == nil || created.After(selected.GetCreateTime().AsTime()) {
+			selected = version
+		}
+	}
+	return selected
+}